@@ -0,0 +1,622 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/TylerStrel/git-secrets-replacer/internal/replacer"
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands = []command{
+	{"scan", "Report every secret found in the history and exit with status 3 if any", runScan},
+	{"rewrite", "Replace secrets across the history and update all refs", runRewrite},
+	{"verify", "Check that no secret is left in the history of any ref", runVerify},
+	{"push", "Force push all refs to the remote/origin", runPush},
+	{"discover", "Find high-entropy strings that may be secrets and write them as a secrets file", runDiscover},
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func printCommands() {
+	fmt.Println("Usage: git-secrets-replacer <command> [flags]\n\nCommands:")
+	for _, cmd := range commands {
+		fmt.Printf("  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Println("\nRun 'git-secrets-replacer <command> --help' for the flags of a command.")
+	fmt.Println("Running without arguments starts the interactive prompts.")
+}
+
+// byteSize is a flag value holding a number of bytes, written as a plain
+// number or with a KB, MB or GB suffix (powers of 1024).
+type byteSize int64
+
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(raw string) error {
+	value := strings.ToUpper(strings.TrimSpace(raw))
+	multiplier := int64(1)
+	for suffix, m := range map[string]int64{"KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30} {
+		if strings.HasSuffix(value, suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, suffix))
+			multiplier = m
+			break
+		}
+	}
+	value = strings.TrimSuffix(value, "B")
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q", raw)
+	}
+	*b = byteSize(n * multiplier)
+	return nil
+}
+
+func newFlagSet(name string, s *settings, withSecrets bool) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&s.repoPath, "repoPath", ".", "Path to the repository that the code will run on")
+	if withSecrets {
+		fs.StringVar(&s.secretsFilePath, "secretsFilePath", "", "Path to the file containing all the secrets that need to be removed")
+		addFilterFlags(fs, s)
+		fs.StringVar(&s.replacement, "replacement", replacer.DefaultReplacement, "Text that replaces secrets without a replacement of their own in the secrets file")
+		fs.StringVar(&s.namedReplacement, "named-replacement", replacer.DefaultNamedReplacement, "Template that replaces named secrets without a replacement of their own, where {name} is the name")
+		fs.Func("encoded-variants", "Also redact these encodings of every literal secret, comma separated: "+strings.Join(replacer.Encodings, ", ")+" or all", func(list string) error {
+			encodings, err := replacer.ParseEncodings(list)
+			s.encodings = encodings
+			return err
+		})
+		fs.Func("detectors", "Also find well-known credential formats, comma separated: "+detectorIDs()+", or all, with -id to disable one", func(list string) error {
+			detectors, err := replacer.ParseDetectors(list)
+			s.detectors = detectors
+			return err
+		})
+		fs.BoolVar(&s.commitMessages, "commit-messages", true, "Also replace secrets in commit messages")
+		fs.BoolVar(&s.tagMessages, "tag-messages", true, "Also replace secrets in annotated tag messages")
+		fs.BoolVar(&s.notes, "notes", true, "Also replace secrets in the notes under "+replacer.NotesRefPrefix)
+		fs.BoolVar(&s.identities, "identities", false, "Also replace secrets in author, committer and tagger names and emails")
+		fs.StringVar(&s.mailmapPath, "mailmap", "", "Path to a file in the .mailmap format mapping author, committer and tagger identities to the ones to write instead")
+		s.memoryBudget = replacer.DefaultMemoryBudget
+		fs.Var(&s.memoryBudget, "memory-budget", "Memory available for caches and files held in memory, e.g. 1GB")
+		fs.Var(&s.cacheBudget, "cache-budget", "Memory available for caching commit objects (default half of --memory-budget)")
+		fs.Var(&s.largeBlobSize, "large-blob-threshold", "Stream files larger than this instead of reading them into memory (default a quarter of --memory-budget)")
+		fs.Var(&s.maxBlobSize, "max-blob-size", "Skip files larger than this and list them in the report (default no limit)")
+	}
+	return fs
+}
+
+// parseFlags parses args into s and loads the secrets file when requested. The
+// returned bool reports whether the command should stop with the given code.
+func parseFlags(fs *flag.FlagSet, args []string, s *settings, withSecrets bool) (int, bool) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK, true
+		}
+		return exitUsage, true
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "Unexpected argument %q (boolean flags take the form --flag=value)\n", fs.Arg(0))
+		fs.Usage()
+		return exitUsage, true
+	}
+
+	if withSecrets {
+		if s.secretsFilePath == "" && len(s.detectors) == 0 {
+			fmt.Fprintln(os.Stderr, "The --secretsFilePath or --detectors flag is required")
+			fs.Usage()
+			return exitUsage, true
+		}
+		if err := loadSecrets(s); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading secrets file: %v\n", err)
+			return exitError, true
+		}
+		if err := loadAllowlist(s); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading ignore file: %v\n", err)
+			return exitError, true
+		}
+		if s.mailmapPath != "" {
+			mailmap, err := replacer.ReadMailmap(s.mailmapPath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading mailmap file: %v\n", err)
+				return exitError, true
+			}
+			s.mailmap = mailmap
+		}
+	}
+	return exitOK, false
+}
+
+// addFilterFlags adds the flags that choose which files and matches are left
+// alone.
+func addFilterFlags(fs *flag.FlagSet, s *settings) {
+	fs.StringVar(&s.ignoreFilePath, "ignore-file", "", "Path to the allowlist of values, paths and commits to leave alone (default "+replacer.DefaultIgnoreFile+" in the repository, if present)")
+	fs.Func("include-path", "Only scan and rewrite files matching these path globs, comma separated, e.g. config/**", func(list string) error {
+		globs, err := replacer.ParsePathGlobs(list)
+		s.includePaths = append(s.includePaths, globs...)
+		return err
+	})
+	fs.Func("exclude-path", "Leave files matching these path globs alone, comma separated, e.g. vendor/**,node_modules/**", func(list string) error {
+		globs, err := replacer.ParsePathGlobs(list)
+		s.excludePaths = append(s.excludePaths, globs...)
+		return err
+	})
+}
+
+// loadAllowlist reads the ignore file given with --ignore-file, or else the
+// one at the root of the repository when there is one.
+func loadAllowlist(s *settings) error {
+	if s.ignoreFilePath == "" {
+		defaultPath := filepath.Join(s.repoPath, replacer.DefaultIgnoreFile)
+		if _, err := os.Stat(defaultPath); err != nil {
+			return nil
+		}
+		s.ignoreFilePath = defaultPath
+	}
+
+	allowlist, err := replacer.ReadAllowlist(s.ignoreFilePath)
+	if err != nil {
+		return err
+	}
+	s.allowlist = allowlist
+	return nil
+}
+
+func loadSecrets(s *settings) error {
+	if s.secretsFilePath == "" && len(s.detectors) == 0 {
+		return errors.New("no secrets file given")
+	}
+	if s.secretsFilePath != "" {
+		secrets, err := replacer.ReadSecrets(s.secretsFilePath)
+		if err != nil {
+			return err
+		}
+		if len(secrets) == 0 {
+			return fmt.Errorf("no secrets found in %s", s.secretsFilePath)
+		}
+		s.secrets = secrets
+	}
+
+	secrets := replacer.WithEncodedVariants(s.secrets, s.encodings)
+	secrets = append(secrets, replacer.DetectorSecrets(s.detectors)...)
+	s.matcher = replacer.NewSecretMatcher(secrets)
+	return nil
+}
+
+func detectorIDs() string {
+	ids := make([]string, len(replacer.Detectors))
+	for i, detector := range replacer.Detectors {
+		ids[i] = detector.ID
+	}
+	return strings.Join(ids, ", ")
+}
+
+func runRewrite(args []string) int {
+	var s settings
+	fs := newFlagSet("rewrite", &s, true)
+	fs.BoolVar(&s.forcePushToOrigin, "forcePushToOrigin", false, "Force push the changes to the remote/origin")
+	fs.BoolVar(&s.assumeYes, "yes", false, "Skip the confirmation prompt")
+	fs.BoolVar(&s.dryRun, "dry-run", false, "Report what would change without writing objects or updating refs")
+	if code, done := parseFlags(fs, args, &s, true); done {
+		return code
+	}
+
+	if !confirmSettings(bufio.NewReader(os.Stdin), s) {
+		fmt.Println("Exiting. Please run the program again with the correct settings.")
+		return exitError
+	}
+
+	if err := rewriteHistory(s); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
+
+	printFooter()
+	return exitOK
+}
+
+func runPush(args []string) int {
+	var s settings
+	fs := newFlagSet("push", &s, false)
+	fs.BoolVar(&s.assumeYes, "yes", false, "Skip the confirmation prompt")
+	if code, done := parseFlags(fs, args, &s, false); done {
+		return code
+	}
+
+	s.forcePushToOrigin = true
+	if !confirmSettings(bufio.NewReader(os.Stdin), s) {
+		fmt.Println("Exiting. Please run the program again with the correct settings.")
+		return exitError
+	}
+
+	if err := changeDirectory(s.repoPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
+
+	refs, err := replacer.GetRefs()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting refs: %v\n", err)
+		return exitError
+	}
+
+	for _, ref := range refs {
+		if !replacer.IsPushedRef(ref) {
+			continue
+		}
+		if err := replacer.ForcePush(ref); err != nil {
+			fmt.Fprintf(os.Stderr, "Error force pushing to origin: %v\n", err)
+			return exitError
+		}
+	}
+	return exitOK
+}
+
+func runScan(args []string) int {
+	var s settings
+	fs := newFlagSet("scan", &s, true)
+	if code, done := parseFlags(fs, args, &s, true); done {
+		return code
+	}
+
+	if err := scanHistory(s); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
+
+	printSkipped()
+	printSuppressed()
+	findings := len(replacer.RunReport.Findings)
+	if findings == 0 {
+		fmt.Println("No secrets found.")
+		return exitOK
+	}
+	replacer.RunReport.PrintFindings(os.Stdout)
+	fmt.Printf("Found %d secret(s).\n", findings)
+	return exitSecretsFound
+}
+
+func runVerify(args []string) int {
+	var s settings
+	fs := newFlagSet("verify", &s, true)
+	if code, done := parseFlags(fs, args, &s, true); done {
+		return code
+	}
+
+	if err := scanHistory(s); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
+
+	printSkipped()
+	printSuppressed()
+	if findings := len(replacer.RunReport.Findings); findings > 0 {
+		replacer.RunReport.PrintFindings(os.Stdout)
+		fmt.Printf("Verification failed: %d secret(s) are still present.\n", findings)
+		return exitSecretsFound
+	}
+	fmt.Println("Verification passed: no secrets found in the history of any ref.")
+	return exitOK
+}
+
+// printSkipped lists the files left unscanned because of --max-blob-size.
+func printSkipped() {
+	if skipped := len(replacer.RunReport.Skipped); skipped > 0 {
+		fmt.Printf("Skipped %d file(s) larger than --max-blob-size:\n", skipped)
+		replacer.RunReport.PrintSkipped(os.Stdout)
+	}
+}
+
+// printSuppressed reports what the ignore file left out of the findings.
+func printSuppressed() {
+	if suppressed := replacer.RunReport.Suppressed; suppressed > 0 {
+		fmt.Printf("Suppressed %d finding(s) allowed by the ignore file.\n", suppressed)
+	}
+	if ignored := len(replacer.RunReport.IgnoredCommits); ignored > 0 {
+		fmt.Printf("Ignored %d commit(s) listed in the ignore file.\n", ignored)
+	}
+}
+
+func runDiscover(args []string) int {
+	var s settings
+	var outputPath, charsets, keywords string
+	options := replacer.DiscoverOptions{Thresholds: make(map[string]float64)}
+	thresholds := map[string]*float64{"hex": new(float64), "base64": new(float64)}
+
+	fs := newFlagSet("discover", &s, false)
+	fs.StringVar(&outputPath, "output", "", "Write the candidates to this file in the secrets file format, for review")
+	fs.IntVar(&options.MinLength, "min-length", 20, "Minimum length of a candidate")
+	fs.StringVar(&charsets, "charsets", "hex,base64", "Character sets to look for, comma separated: hex, base64")
+	fs.Float64Var(thresholds["hex"], "hex-entropy", replacer.DefaultThresholds["hex"], "Minimum entropy in bits per character of hex candidates")
+	fs.Float64Var(thresholds["base64"], "base64-entropy", replacer.DefaultThresholds["base64"], "Minimum entropy in bits per character of base64 candidates")
+	fs.StringVar(&keywords, "keywords", strings.Join(replacer.DefaultKeywords, ","), "Words that mark a line as likely to hold a credential, comma separated")
+	fs.BoolVar(&options.RequireKeyword, "require-keyword", false, "Only report candidates on lines containing a keyword")
+	addFilterFlags(fs, &s)
+	if code, done := parseFlags(fs, args, &s, false); done {
+		return code
+	}
+	if err := loadAllowlist(&s); err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading ignore file: %v\n", err)
+		return exitError
+	}
+
+	for _, charset := range strings.Split(charsets, ",") {
+		charset = strings.ToLower(strings.TrimSpace(charset))
+		threshold, known := thresholds[charset]
+		if !known {
+			fmt.Fprintf(os.Stderr, "Unknown character set %q (expected hex or base64)\n", charset)
+			return exitUsage
+		}
+		options.Thresholds[charset] = *threshold
+	}
+	for _, keyword := range strings.Split(keywords, ",") {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			options.Keywords = append(options.Keywords, keyword)
+		}
+	}
+
+	// The walk changes into the repository, so the output path is resolved
+	// from where the tool was started first.
+	if outputPath != "" {
+		absPath, err := filepath.Abs(outputPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitError
+		}
+		outputPath = absPath
+	}
+
+	discovery := replacer.NewDiscovery(options)
+	if err := discoverHistory(s, discovery); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
+
+	if skipped := len(replacer.RunReport.Skipped); skipped > 0 {
+		fmt.Printf("Skipped %d file(s) larger than %d bytes, which discover does not read:\n", skipped, replacer.LargeBlobThreshold)
+		replacer.RunReport.PrintSkipped(os.Stdout)
+	}
+	candidates := discovery.Candidates
+	if outputPath != "" {
+		if err := writeCandidates(outputPath, candidates); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing candidates: %v\n", err)
+			return exitError
+		}
+	}
+	if len(candidates) == 0 {
+		fmt.Println("No candidates found.")
+		return exitOK
+	}
+	replacer.PrintCandidates(os.Stdout, candidates)
+	fmt.Printf("Found %d candidate(s).\n", len(candidates))
+	if outputPath != "" {
+		fmt.Printf("Wrote them to %s for review.\n", outputPath)
+	}
+	return exitSecretsFound
+}
+
+// writeCandidates writes candidates as a secrets file readable only by the
+// owner, since it holds the values in plain text.
+func writeCandidates(path string, candidates []replacer.Candidate) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := replacer.WriteSecretsFile(file, candidates); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func changeDirectory(repoPath string) error {
+	fmt.Fprintln(replacer.Output, "Changing directory to:", repoPath)
+	if err := os.Chdir(repoPath); err != nil {
+		return fmt.Errorf("error changing directory: %w", err)
+	}
+	return nil
+}
+
+// scanHistory walks every ref the same way a rewrite does, in dry-run mode and
+// without progress output, so that RunReport holds the findings.
+func scanHistory(s settings) error {
+	replacer.Output = io.Discard
+	defer func() { replacer.Output = os.Stdout }()
+
+	return walkHistory(s, true)
+}
+
+// discoverHistory walks every ref oldest commit first, so that candidates are
+// reported with the commit that introduced them.
+func discoverHistory(s settings, discovery *replacer.Discovery) error {
+	replacer.Output = io.Discard
+	defer func() { replacer.Output = os.Stdout }()
+
+	if err := changeDirectory(s.repoPath); err != nil {
+		return err
+	}
+	replacer.ResetRun()
+	replacer.Ignore = s.allowlist
+	replacer.IncludePaths, replacer.ExcludePaths = s.includePaths, s.excludePaths
+	replacer.LargeBlobThreshold = replacer.DefaultLargeBlobThreshold
+	defer replacer.CloseObjects()
+
+	refs, err := replacer.GetRefs()
+	if err != nil {
+		return fmt.Errorf("error getting refs: %w", err)
+	}
+
+	for _, ref := range refs {
+		replacer.RunReport.SetRef(ref)
+		_, peeledType, err := replacer.ResolveRef(ref)
+		if err != nil {
+			return fmt.Errorf("error resolving ref %s: %w", ref, err)
+		}
+		if peeledType != "commit" {
+			continue
+		}
+		commits, err := replacer.GetCommits(ref)
+		if err != nil {
+			return fmt.Errorf("error getting commits for ref %s: %w", ref, err)
+		}
+		for i := len(commits) - 1; i >= 0; i-- {
+			if err := discovery.ScanCommit(commits[i]); err != nil {
+				return fmt.Errorf("error scanning commit %s: %w", commits[i], err)
+			}
+		}
+	}
+	return nil
+}
+
+func rewriteHistory(s settings) error {
+	if err := walkHistory(s, s.dryRun); err != nil {
+		return err
+	}
+
+	if s.dryRun {
+		fmt.Println("\nDry run: no objects were written and no refs were updated.")
+		replacer.RunReport.Print(os.Stdout)
+		return nil
+	}
+
+	fmt.Println("Repository has been rewritten successfully.")
+	if changed := len(replacer.RunReport.ChangedCommits); changed > 0 {
+		fmt.Printf("Rewrote %d commit(s):\n", changed)
+		replacer.RunReport.PrintChangedCommits(os.Stdout)
+	}
+	printSkipped()
+	printSuppressed()
+	return nil
+}
+
+// applyMemorySettings divides the memory budget between the caches and blobs
+// held in memory, then applies the limits that were set explicitly.
+func applyMemorySettings(s settings) {
+	replacer.SetMemoryBudget(int64(s.memoryBudget))
+	if s.cacheBudget > 0 {
+		replacer.SetCacheBudget(int64(s.cacheBudget))
+	}
+	if s.largeBlobSize > 0 {
+		replacer.LargeBlobThreshold = int64(s.largeBlobSize)
+	}
+	replacer.MaxBlobSize = int64(s.maxBlobSize)
+}
+
+func walkHistory(s settings, dryRun bool) error {
+	if err := changeDirectory(s.repoPath); err != nil {
+		return err
+	}
+	replacer.ResetRun()
+	replacer.DryRun = dryRun
+	replacer.Ignore = s.allowlist
+	replacer.IncludePaths, replacer.ExcludePaths = s.includePaths, s.excludePaths
+	replacer.Replacement = s.replacement
+	replacer.NamedReplacement = s.namedReplacement
+	replacer.RedactCommitMessages = s.commitMessages
+	replacer.RedactTagMessages = s.tagMessages
+	replacer.RedactNotes = s.notes
+	replacer.Identities, replacer.RedactIdentities = s.mailmap, s.identities
+	applyMemorySettings(s)
+	defer replacer.CloseObjects()
+
+	refs, err := replacer.GetRefs()
+	if err != nil {
+		return fmt.Errorf("error getting refs: %w", err)
+	}
+	// Notes are moved to the rewritten IDs of the objects they annotate, so
+	// they are processed once everything else is.
+	sort.SliceStable(refs, func(i, j int) bool {
+		return !replacer.IsNotesRef(refs[i]) && replacer.IsNotesRef(refs[j])
+	})
+
+	newHeads := make(map[string]string, len(refs))
+	for _, ref := range refs {
+		fmt.Fprintln(replacer.Output, "Processing ref:", ref)
+		replacer.RunReport.SetRef(ref)
+		head, peeledType, err := replacer.ResolveRef(ref)
+		if err != nil {
+			return fmt.Errorf("error resolving ref %s: %w", ref, err)
+		}
+
+		// Tags can also point at a tree or blob, which has no history.
+		var commits []string
+		if peeledType == "commit" {
+			commits, err = replacer.GetCommits(ref)
+			if err != nil {
+				return fmt.Errorf("error getting commits for ref %s: %w", ref, err)
+			}
+		}
+
+		processCommit := replacer.ProcessCommit
+		if replacer.IsNotesRef(ref) {
+			processCommit = replacer.ProcessNotesCommit
+		}
+		for i := len(commits) - 1; i >= 0; i-- {
+			commit := commits[i]
+			fmt.Fprintln(replacer.Output, "Processing commit:", commit)
+			newCommit, err := processCommit(commit, s.matcher)
+			if err != nil {
+				return fmt.Errorf("error processing commit %s: %w", commit, err)
+			}
+			replacer.CommitMap[commit] = newCommit
+		}
+
+		newHead, err := replacer.ProcessRef(head, s.matcher)
+		if err != nil {
+			return fmt.Errorf("error processing ref %s: %w", ref, err)
+		}
+		replacer.RunReport.AddRefUpdate(ref, head, newHead)
+		newHeads[ref] = newHead
+	}
+
+	commits, trees := replacer.ObjectCacheStats()
+	fmt.Fprintln(replacer.Output, "Commit cache:", commits)
+	fmt.Fprintln(replacer.Output, "Tree cache:", trees)
+
+	if dryRun {
+		return nil
+	}
+
+	// Refs can only point at stored objects, so everything is written as one
+	// pack before the first ref moves.
+	if err := replacer.FlushObjects(); err != nil {
+		return fmt.Errorf("error writing objects: %w", err)
+	}
+
+	for _, ref := range refs {
+		newHead := newHeads[ref]
+		fmt.Println("Updating ref:", ref, "to new hash:", newHead)
+		if err := replacer.UpdateRef(ref, newHead); err != nil {
+			return fmt.Errorf("error updating ref %s: %w", ref, err)
+		}
+
+		if s.forcePushToOrigin && replacer.IsPushedRef(ref) {
+			if err := replacer.ForcePush(ref); err != nil {
+				return fmt.Errorf("error force pushing to origin: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package replacer
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+)
+
+// DefaultCacheBudget is the memory budget of the object caches unless
+// SetCacheBudget is called.
+const DefaultCacheBudget = 256 << 20
+
+// DefaultMemoryBudget is the budget SetMemoryBudget divides into the default
+// cache budget and large blob threshold.
+const DefaultMemoryBudget = 2 * DefaultCacheBudget
+
+// DefaultLargeBlobThreshold is the size above which blobs are streamed unless
+// LargeBlobThreshold is changed.
+const DefaultLargeBlobThreshold = DefaultMemoryBudget / 4
+
+// cacheEntryOverhead approximates the bookkeeping cost of one entry on top of
+// its key and value.
+const cacheEntryOverhead = 96
+
+// ObjectCache is a least-recently-used cache bounded by the approximate number
+// of bytes its entries hold.
+type ObjectCache struct {
+	mu        sync.Mutex
+	budget    int64
+	used      int64
+	order     *list.List
+	items     map[string]*list.Element
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+type cacheEntry struct {
+	key   string
+	value any
+	size  int64
+}
+
+type CacheStats struct {
+	Entries   int
+	Bytes     int64
+	Budget    int64
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+func (s CacheStats) String() string {
+	return fmt.Sprintf("%d entries, %d of %d bytes, %d hits, %d misses, %d evictions",
+		s.Entries, s.Bytes, s.Budget, s.Hits, s.Misses, s.Evictions)
+}
+
+func NewObjectCache(budget int64) *ObjectCache {
+	return &ObjectCache{budget: budget, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (c *ObjectCache) Get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, found := c.items[key]
+	if !found {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.order.MoveToFront(element)
+	return element.Value.(*cacheEntry).value, true
+}
+
+// Put stores value under key, evicting the least recently used entries until
+// the cache fits its budget again. Values larger than the budget are not kept.
+func (c *ObjectCache) Put(key string, value any, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	size += int64(len(key)) + cacheEntryOverhead
+	if element, found := c.items[key]; found {
+		c.remove(element)
+	}
+	if size > c.budget {
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&cacheEntry{key: key, value: value, size: size})
+	c.used += size
+	for c.used > c.budget {
+		c.remove(c.order.Back())
+		c.evictions++
+	}
+}
+
+func (c *ObjectCache) remove(element *list.Element) {
+	entry := c.order.Remove(element).(*cacheEntry)
+	delete(c.items, entry.key)
+	c.used -= entry.size
+}
+
+func (c *ObjectCache) SetBudget(budget int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.budget = budget
+	for c.used > c.budget {
+		c.remove(c.order.Back())
+		c.evictions++
+	}
+}
+
+func (c *ObjectCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{
+		Entries:   len(c.items),
+		Bytes:     c.used,
+		Budget:    c.budget,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+}
+
+// SetCacheBudget splits budget bytes between the commit object cache and the
+// much smaller commit-to-tree cache.
+func SetCacheBudget(budget int64) {
+	commitCache.SetBudget(budget - budget/8)
+	treeCache.SetBudget(budget / 8)
+}
+
+// SetMemoryBudget divides budget bytes between the object caches, which get
+// half, and blobs held in memory. A blob is read into memory only while it and
+// its redacted copy fit in the other half; larger blobs are streamed.
+func SetMemoryBudget(budget int64) {
+	SetCacheBudget(budget / 2)
+	LargeBlobThreshold = budget / 4
+}
+
+func ObjectCacheStats() (commits, trees CacheStats) {
+	return commitCache.Stats(), treeCache.Stats()
+}
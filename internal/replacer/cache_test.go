@@ -0,0 +1,71 @@
+package replacer
+
+import "testing"
+
+func TestObjectCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	entrySize := int64(10 + 1 + cacheEntryOverhead)
+	cache := NewObjectCache(3 * entrySize)
+
+	cache.Put("a", "A", 10)
+	cache.Put("b", "B", 10)
+	cache.Put("c", "C", 10)
+	if _, found := cache.Get("a"); !found {
+		t.Fatal("expected 'a' to be cached")
+	}
+	cache.Put("d", "D", 10)
+
+	if _, found := cache.Get("b"); found {
+		t.Error("expected 'b' to be evicted as least recently used")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, found := cache.Get(key); !found {
+			t.Errorf("expected %q to be cached", key)
+		}
+	}
+
+	stats := cache.Stats()
+	if stats.Entries != 3 || stats.Bytes != 3*entrySize || stats.Evictions != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if stats.Hits != 4 || stats.Misses != 1 {
+		t.Errorf("expected 4 hits and 1 miss, got %+v", stats)
+	}
+}
+
+func TestObjectCacheBudget(t *testing.T) {
+	cache := NewObjectCache(1000)
+
+	cache.Put("huge", "value", 5000)
+	if _, found := cache.Get("huge"); found {
+		t.Error("expected a value larger than the budget not to be cached")
+	}
+
+	cache.Put("a", "A", 400)
+	cache.Put("a", "A2", 400)
+	if value, _ := cache.Get("a"); value != "A2" {
+		t.Errorf("expected replaced value 'A2', got %v", value)
+	}
+	if stats := cache.Stats(); stats.Entries != 1 || stats.Bytes != 400+1+cacheEntryOverhead {
+		t.Errorf("unexpected stats after replacing an entry %+v", stats)
+	}
+
+	cache.SetBudget(100)
+	if stats := cache.Stats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Errorf("expected shrinking the budget to evict everything, got %+v", stats)
+	}
+}
+
+func TestSetMemoryBudget(t *testing.T) {
+	commitCache = NewObjectCache(DefaultCacheBudget)
+	treeCache = NewObjectCache(DefaultCacheBudget)
+	defer SetMemoryBudget(DefaultMemoryBudget)
+
+	SetMemoryBudget(1 << 20)
+	commits, trees := ObjectCacheStats()
+	if commits.Budget+trees.Budget != 1<<19 {
+		t.Errorf("expected half the budget to go to the caches, got %d and %d", commits.Budget, trees.Budget)
+	}
+	if LargeBlobThreshold != 1<<18 {
+		t.Errorf("expected a large blob threshold of a quarter of the budget, got %d", LargeBlobThreshold)
+	}
+}
@@ -0,0 +1,106 @@
+package replacer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// CommitHeader is one header of a commit object. The continuation lines of
+// multi-line headers such as gpgsig and mergetag are part of Value, joined by
+// "\n" without the space that starts them in the object.
+type CommitHeader struct {
+	Key   string
+	Value string
+}
+
+// Commit is a parsed commit object: its headers in order, and the message,
+// which is kept as raw bytes in whatever the encoding header says. Bytes
+// returns the object exactly as it was parsed unless something was changed.
+type Commit struct {
+	Headers []CommitHeader
+	Message []byte
+	// hasMessage is set when the headers end with a blank line, which git
+	// always writes, even for an empty message.
+	hasMessage bool
+}
+
+// signatureHeaders hold signatures over the rest of the commit, which no
+// longer verify once anything in it changes.
+var signatureHeaders = []string{"gpgsig", "gpgsig-sha256"}
+
+// ParseCommit splits the content of a commit object into headers and message.
+// Only the header section is interpreted, so message lines that look like
+// headers, such as "parent company rename", are left alone. Tag objects have
+// the same layout and are parsed with it too.
+func ParseCommit(content []byte) (*Commit, error) {
+	c := &Commit{}
+	for rest := content; len(rest) > 0; {
+		line, after, found := bytes.Cut(rest, []byte("\n"))
+		if !found {
+			return nil, errors.New("unterminated header")
+		}
+		rest = after
+
+		if len(line) == 0 {
+			c.Message, c.hasMessage = rest, true
+			break
+		}
+		if line[0] == ' ' {
+			if len(c.Headers) == 0 {
+				return nil, errors.New("continuation line before the first header")
+			}
+			c.Headers[len(c.Headers)-1].Value += "\n" + string(line[1:])
+			continue
+		}
+
+		key, value, found := bytes.Cut(line, []byte(" "))
+		if !found {
+			return nil, fmt.Errorf("malformed header %q", line)
+		}
+		c.Headers = append(c.Headers, CommitHeader{Key: string(key), Value: string(value)})
+	}
+	return c, nil
+}
+
+// Bytes serializes the commit in the object format.
+func (c *Commit) Bytes() []byte {
+	var buf bytes.Buffer
+	for _, header := range c.Headers {
+		buf.WriteString(header.Key)
+		buf.WriteByte(' ')
+		buf.WriteString(strings.ReplaceAll(header.Value, "\n", "\n "))
+		buf.WriteByte('\n')
+	}
+	if c.hasMessage {
+		buf.WriteByte('\n')
+		buf.Write(c.Message)
+	}
+	return buf.Bytes()
+}
+
+// Header returns the value of the first header named key.
+func (c *Commit) Header(key string) (string, bool) {
+	for _, header := range c.Headers {
+		if header.Key == key {
+			return header.Value, true
+		}
+	}
+	return "", false
+}
+
+// dropSignatures removes the headers holding signatures over the commit.
+func (c *Commit) dropSignatures() {
+	headers := c.Headers[:0]
+	for _, header := range c.Headers {
+		isSignature := false
+		for _, key := range signatureHeaders {
+			isSignature = isSignature || header.Key == key
+		}
+		if !isSignature {
+			headers = append(headers, header)
+		}
+	}
+	c.Headers = headers
+}
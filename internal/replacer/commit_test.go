@@ -0,0 +1,134 @@
+package replacer
+
+import (
+	"strings"
+	"testing"
+)
+
+const signedCommit = "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+	"parent 1111111111111111111111111111111111111111\n" +
+	"parent 2222222222222222222222222222222222222222\n" +
+	"author A U Thor <author@example.com> 1700000000 +0100\n" +
+	"committer C O Mitter <committer@example.com> 1700000000 +0100\n" +
+	"encoding ISO-8859-1\n" +
+	"mergetag object 2222222222222222222222222222222222222222\n" +
+	" type commit\n" +
+	" tag v1\n" +
+	" \n" +
+	" tag message\n" +
+	"gpgsig -----BEGIN PGP SIGNATURE-----\n" +
+	" \n" +
+	" iQEzBAABCAAdFiEE\n" +
+	" -----END PGP SIGNATURE-----\n" +
+	"\n" +
+	"Merge tag 'v1'\n\nparent company rename\ntree of life\n"
+
+func TestParseCommitRoundTrip(t *testing.T) {
+	for _, content := range []string{
+		signedCommit,
+		"tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\nauthor A <a@b.c> 1 +0000\ncommitter A <a@b.c> 1 +0000\n\n",
+		"tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\nauthor A <a@b.c> 1 +0000\ncommitter A <a@b.c> 1 +0000\n\nno trailing newline",
+		"tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\nextra \n\n\n\nmessage after blank lines\n",
+	} {
+		commit, err := ParseCommit([]byte(content))
+		if err != nil {
+			t.Fatalf("ParseCommit(%q) error = %v", content, err)
+		}
+		if got := string(commit.Bytes()); got != content {
+			t.Errorf("expected a byte-for-byte round trip\nwant %q\ngot  %q", content, got)
+		}
+	}
+}
+
+func TestParseCommitHeaders(t *testing.T) {
+	commit, err := ParseCommit([]byte(signedCommit))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if tree, _ := commit.Header("tree"); tree != "4b825dc642cb6eb9a060e54bf8d69288fbee4904" {
+		t.Errorf("unexpected tree %q", tree)
+	}
+	var parents []string
+	for _, header := range commit.Headers {
+		if header.Key == "parent" {
+			parents = append(parents, header.Value)
+		}
+	}
+	if len(parents) != 2 || parents[1] != "2222222222222222222222222222222222222222" {
+		t.Errorf("expected only the parent headers, got %v", parents)
+	}
+	if encoding, _ := commit.Header("encoding"); encoding != "ISO-8859-1" {
+		t.Errorf("unexpected encoding %q", encoding)
+	}
+	if mergetag, _ := commit.Header("mergetag"); !strings.HasSuffix(mergetag, "\ntag v1\n\ntag message") {
+		t.Errorf("expected the mergetag continuation lines in its value, got %q", mergetag)
+	}
+	if !strings.HasPrefix(string(commit.Message), "Merge tag 'v1'\n\nparent company rename") {
+		t.Errorf("unexpected message %q", commit.Message)
+	}
+}
+
+func TestParseCommitRejectsMalformedHeaders(t *testing.T) {
+	for _, content := range []string{" continuation\n\n", "tree\n\n", "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904"} {
+		if _, err := ParseCommit([]byte(content)); err == nil {
+			t.Errorf("expected an error for %q", content)
+		}
+	}
+}
+
+func TestProcessCommit_MessageLinesLookingLikeHeaders(t *testing.T) {
+	dir := useTestRepo(t)
+	commitFiles(t, dir, map[string]string{"config.txt": "key=secret\n"}, "first\n\nparent company rename\ntree of life")
+	commit := runGit(t, dir, "", "rev-parse", "HEAD")
+
+	newCommit, err := ProcessCommit(commit, NewMatcher([]string{"secret"}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := FlushObjects(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	original := runGit(t, dir, "", "cat-file", "commit", commit)
+	rewritten := runGit(t, dir, "", "cat-file", "commit", newCommit)
+	_, originalMessage, _ := strings.Cut(original, "\n\n")
+	_, rewrittenMessage, _ := strings.Cut(rewritten, "\n\n")
+	if rewrittenMessage != originalMessage {
+		t.Errorf("expected the message to be kept, got %q", rewrittenMessage)
+	}
+	if got := runGit(t, dir, "", "cat-file", "-p", newCommit+":config.txt"); got != "key=**REMOVED**" {
+		t.Errorf("unexpected content %q", got)
+	}
+}
+
+func TestProcessCommit_DropsSignatureOfChangedCommit(t *testing.T) {
+	dir := useTestRepo(t)
+	tree := runGit(t, dir, "", "mktree")
+	blob := runGit(t, dir, "key=secret\n", "hash-object", "-w", "--stdin")
+	secretTree := runGit(t, dir, "100644 blob "+blob+"\tconfig.txt\n", "mktree")
+
+	signed := func(tree string) string {
+		content := strings.Replace(signedCommit, "4b825dc642cb6eb9a060e54bf8d69288fbee4904", tree, 1)
+		content = strings.Replace(content, "parent 1111111111111111111111111111111111111111\nparent 2222222222222222222222222222222222222222\n", "", 1)
+		return runGit(t, dir, content, "hash-object", "-t", "commit", "-w", "--stdin")
+	}
+
+	matcher := NewMatcher([]string{"secret"})
+	clean := signed(tree)
+	if newCommit, err := ProcessCommit(clean, matcher); err != nil || newCommit != clean {
+		t.Errorf("expected an unchanged signed commit to be kept, got %s, %v", newCommit, err)
+	}
+
+	newCommit, err := ProcessCommit(signed(secretTree), matcher)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := FlushObjects(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rewritten := runGit(t, dir, "", "cat-file", "commit", newCommit)
+	if strings.Contains(rewritten, "gpgsig") || !strings.Contains(rewritten, "mergetag object") || !strings.Contains(rewritten, "encoding ISO-8859-1") {
+		t.Errorf("expected only the signature to be dropped, got %q", rewritten)
+	}
+}
@@ -0,0 +1,77 @@
+package replacer
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Detector is a built-in rule for a well-known credential format. Its matches
+// are reported and replaced under the detector ID, like a named secret.
+type Detector struct {
+	ID          string
+	Description string
+	Pattern     string
+}
+
+// Detectors is the built-in detector library, in the order "all" expands to.
+var Detectors = []Detector{
+	{"aws-access-key", "AWS access key IDs", `\b(?:AKIA|ASIA|ABIA|ACCA)[0-9A-Z]{16}\b`},
+	{"github-token", "GitHub personal access, OAuth, app and refresh tokens", `\b(?:gh[pousr]_[A-Za-z0-9]{36}|github_pat_[A-Za-z0-9_]{82})\b`},
+	{"gitlab-token", "GitLab personal access tokens", `\bglpat-[A-Za-z0-9_-]{20,50}`},
+	{"slack-webhook", "Slack incoming webhook URLs", `https://hooks\.slack\.com/services/T[A-Z0-9]{8,12}/B[A-Z0-9]{8,12}/[A-Za-z0-9]{24}`},
+	{"stripe-key", "Stripe secret and restricted keys", `\b[rs]k_(?:live|test)_[0-9A-Za-z]{24,99}\b`},
+	{"jwt", "JSON Web Tokens", `\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`},
+	{"private-key", "PEM private key blocks", `-----BEGIN[A-Z ]{0,30} PRIVATE KEY-----[\s\S]*?-----END[A-Z ]{0,30} PRIVATE KEY-----`},
+	{"connection-string-password", "Passwords embedded in connection string URLs", `\b[A-Za-z][A-Za-z0-9+.-]*://[^\s:/@]+:(?P<secret>[^\s:/@]+)@`},
+}
+
+// ParseDetectors parses a comma separated list of detector IDs. "all" enables
+// every detector and an ID prefixed with "-" disables one again, as in
+// "all,-jwt".
+func ParseDetectors(list string) ([]Detector, error) {
+	enabled := make(map[string]bool)
+	for _, id := range strings.Split(list, ",") {
+		id = strings.ToLower(strings.TrimSpace(id))
+		id, disable := strings.CutPrefix(id, "-")
+		switch {
+		case id == "":
+		case id == "all":
+			for _, detector := range Detectors {
+				enabled[detector.ID] = !disable
+			}
+		case findDetector(id) != nil:
+			enabled[id] = !disable
+		default:
+			return nil, fmt.Errorf("unknown detector %q", id)
+		}
+	}
+
+	var detectors []Detector
+	for _, detector := range Detectors {
+		if enabled[detector.ID] {
+			detectors = append(detectors, detector)
+		}
+	}
+	return detectors, nil
+}
+
+func findDetector(id string) *Detector {
+	for i := range Detectors {
+		if Detectors[i].ID == id {
+			return &Detectors[i]
+		}
+	}
+	return nil
+}
+
+// DetectorSecrets turns detectors into named regex secrets. Unlike regex
+// entries from a secrets file they keep leftmost-first semantics, so that the
+// lazy body of a private key block stops at the first END line.
+func DetectorSecrets(detectors []Detector) []Secret {
+	secrets := make([]Secret, len(detectors))
+	for i, detector := range detectors {
+		secrets[i] = Secret{Name: detector.ID, Value: detector.Pattern, Regex: regexp.MustCompile(detector.Pattern), Validate: validators[detector.ID]}
+	}
+	return secrets
+}
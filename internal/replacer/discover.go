@@ -0,0 +1,324 @@
+package replacer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Charsets maps the character sets Discover knows to the bytes they allow.
+// A token made only of hex digits is judged as hex, any other token as base64,
+// which allows the dots between the parts of a JWT.
+var Charsets = map[string]func(b byte) bool{
+	"hex": func(b byte) bool {
+		return '0' <= b && b <= '9' || 'a' <= b && b <= 'f' || 'A' <= b && b <= 'F'
+	},
+	"base64": func(b byte) bool {
+		return isTokenByte(b) || b == '=' || b == '.'
+	},
+}
+
+// DefaultThresholds are the minimum Shannon entropies, in bits per byte, of
+// candidates in each character set.
+var DefaultThresholds = map[string]float64{"hex": 3.0, "base64": 4.0}
+
+// DefaultKeywords are words that suggest a nearby string is a credential.
+var DefaultKeywords = []string{"password", "passwd", "pwd", "secret", "token", "apikey", "api_key", "access_key", "private_key", "credential", "auth"}
+
+// DiscoverOptions configures which strings are reported as candidates.
+// Thresholds holds the minimum entropy of every enabled character set.
+type DiscoverOptions struct {
+	MinLength      int
+	Thresholds     map[string]float64
+	Keywords       []string
+	RequireKeyword bool
+}
+
+// Candidate is a string that looks like a secret, with where it was first
+// seen and the keyword found on its line, if any. HighConfidence is set when
+// it passed the offline check of a known token format.
+type Candidate struct {
+	Value          string
+	Charset        string
+	Entropy        float64
+	Keyword        string
+	HighConfidence bool
+	Commit         string
+	Path           string
+	Line           int
+}
+
+// Discovery walks commits looking for high-entropy strings. Every tree, blob
+// and value is only looked at once, so each candidate is reported with the
+// first commit and path it was seen in.
+type Discovery struct {
+	Options    DiscoverOptions
+	Candidates []Candidate
+	commit     string
+	seen       map[string]bool
+	values     map[string]bool
+}
+
+func NewDiscovery(options DiscoverOptions) *Discovery {
+	return &Discovery{Options: options, seen: make(map[string]bool), values: make(map[string]bool)}
+}
+
+// isTokenByte reports whether b belongs in a token. "=" is only allowed as
+// padding at the end, so that "key=value" is not read as a single token.
+func isTokenByte(b byte) bool {
+	return 'A' <= b && b <= 'Z' || 'a' <= b && b <= 'z' || '0' <= b && b <= '9' || b == '+' || b == '/' || b == '_' || b == '-'
+}
+
+// Entropy returns the Shannon entropy of s in bits per byte.
+func Entropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	var counts [256]int
+	for i := 0; i < len(s); i++ {
+		counts[s[i]]++
+	}
+	entropy := 0.0
+	for _, count := range counts {
+		if count > 0 {
+			p := float64(count) / float64(len(s))
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy
+}
+
+// FindCandidates returns the high-entropy tokens in content, with their line.
+// Tokens are runs of base64 characters at least MinLength long, or the three
+// dotted parts of a JWT. Tokens shaped like a known format that fail its
+// offline check are left out.
+func FindCandidates(content []byte, options DiscoverOptions) []Candidate {
+	var candidates []Candidate
+	for lineNo, line := range bytes.Split(content, []byte("\n")) {
+		keyword := findKeyword(line, options.Keywords)
+		if options.RequireKeyword && keyword == "" {
+			continue
+		}
+
+		for start := 0; start < len(line); {
+			if !isTokenByte(line[start]) {
+				start++
+				continue
+			}
+			end := start
+			for end < len(line) && isTokenByte(line[end]) {
+				end++
+			}
+			for pad := 0; pad < 2 && end < len(line) && line[end] == '='; pad++ {
+				end++
+			}
+			end = jwtEnd(line, start, end)
+			token := string(line[start:end])
+			start = end
+
+			if len(token) < options.MinLength {
+				continue
+			}
+			charset := tokenCharset(token, options.Thresholds)
+			if charset == "" {
+				continue
+			}
+			validation := ValidateToken(token)
+			if validation == Invalid {
+				continue
+			}
+			if entropy := Entropy(token); entropy >= options.Thresholds[charset] {
+				candidates = append(candidates, Candidate{Value: token, Charset: charset, Entropy: entropy, Keyword: keyword, HighConfidence: validation == Valid, Line: lineNo + 1})
+			}
+		}
+	}
+	return candidates
+}
+
+// jwtEnd extends the token line[start:end] over the payload and signature of
+// a JWT, so that its three dotted parts make a single candidate. Other tokens
+// keep their end.
+func jwtEnd(line []byte, start, end int) int {
+	if !bytes.HasPrefix(line[start:end], []byte("eyJ")) {
+		return end
+	}
+	next := end
+	for part := 1; part < 3; part++ {
+		if next >= len(line) || line[next] != '.' {
+			return end
+		}
+		next++
+		for next < len(line) && isTokenByte(line[next]) {
+			next++
+		}
+	}
+	return next
+}
+
+// tokenCharset returns the most specific enabled character set token fits.
+func tokenCharset(token string, thresholds map[string]float64) string {
+	for _, name := range []string{"hex", "base64"} {
+		if _, enabled := thresholds[name]; !enabled {
+			continue
+		}
+		fits := true
+		for i := 0; i < len(token) && fits; i++ {
+			fits = Charsets[name](token[i])
+		}
+		if fits {
+			return name
+		}
+	}
+	return ""
+}
+
+func findKeyword(line []byte, keywords []string) string {
+	lower := bytes.ToLower(line)
+	for _, keyword := range keywords {
+		if bytes.Contains(lower, []byte(strings.ToLower(keyword))) {
+			return keyword
+		}
+	}
+	return ""
+}
+
+// ScanCommit looks for candidates in every file of commit that no earlier
+// commit had. Commits, paths and values the allowlist allows are left out.
+func (d *Discovery) ScanCommit(commit string) error {
+	if Ignore.IgnoresCommit(commit) {
+		return nil
+	}
+	tree, err := GetTree(commit)
+	if err != nil {
+		return fmt.Errorf("error getting tree for commit %s: %w", commit, err)
+	}
+	d.commit = commit
+	RunReport.setCommit(commit)
+	return d.scanTree(tree, "")
+}
+
+func (d *Discovery) scanTree(tree, dir string) error {
+	key := resultKey(tree, dir)
+	if d.seen[key] {
+		return nil
+	}
+	d.seen[key] = true
+
+	obj, err := Objects().Read(tree)
+	if err != nil {
+		return fmt.Errorf("error getting tree content for %s: %w", tree, err)
+	}
+	entries, err := ParseTree(obj.Content, len(tree)/2)
+	if err != nil {
+		return fmt.Errorf("error parsing tree %s: %w", tree, err)
+	}
+
+	for _, entry := range entries {
+		fullPath := entry.Name
+		if dir != "" {
+			fullPath = dir + "/" + entry.Name
+		}
+
+		if entry.Mode == "40000" && dirIncluded(fullPath) {
+			err = d.scanTree(entry.Sha, fullPath)
+		} else if (entry.Mode == "100644" || entry.Mode == "100755") && pathIncluded(fullPath) {
+			err = d.scanBlob(entry.Sha, fullPath)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanBlob reads files up to LargeBlobThreshold into memory; larger ones are
+// recorded in RunReport as skipped.
+func (d *Discovery) scanBlob(sha, path string) error {
+	key := resultKey(sha, path)
+	if d.seen[key] || Ignore.IgnoresPath(path) {
+		return nil
+	}
+	d.seen[key] = true
+
+	_, size, err := Objects().Info(sha)
+	if err != nil {
+		return err
+	}
+	if size > LargeBlobThreshold {
+		RunReport.addSkipped(path, sha, size)
+		return nil
+	}
+
+	obj, err := Objects().Read(sha)
+	if err != nil {
+		return err
+	}
+	if IsBinary(obj.Content) {
+		return nil
+	}
+
+	for _, candidate := range FindCandidates(obj.Content, d.Options) {
+		if d.values[candidate.Value] || Ignore.Allows(path, []byte(candidate.Value)) {
+			continue
+		}
+		d.values[candidate.Value] = true
+		candidate.Commit = d.commit
+		candidate.Path = path
+		d.Candidates = append(d.Candidates, candidate)
+	}
+	return nil
+}
+
+// PrintCandidates writes one tab-separated line per candidate: commit, path,
+// line, character set, entropy, keyword and secret identifier, followed by
+// "high-confidence" for candidates that passed an offline check.
+func PrintCandidates(w io.Writer, candidates []Candidate) {
+	for _, c := range candidates {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%.2f\t%s\t%s", c.Commit, pathEscaper.Replace(c.Path), c.Line, c.Charset, c.Entropy, c.Keyword, SecretID(c.Value))
+		if c.HighConfidence {
+			fmt.Fprint(w, "\thigh-confidence")
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// secretsFileHeader starts the secrets files WriteSecretsFile writes.
+var secretsFileHeader = []string{
+	"# Candidates found by git-secrets-replacer discover. Delete every entry",
+	"# that is not a secret before using this file to rewrite the history.",
+}
+
+// candidateComment matches the comment WriteSecretsFile writes above each
+// candidate.
+var candidateComment = regexp.MustCompile(`^# [0-9a-f]{40}(?:[0-9a-f]{24})? .+:\d+ (?:hex|base64) entropy \d+\.\d\d(?: keyword ".*")?(?: high-confidence)?$`)
+
+// isDiscoverComment reports whether line is one of the comments
+// WriteSecretsFile writes, which are the only comments a secrets file holds.
+func isDiscoverComment(line string) bool {
+	return slices.Contains(secretsFileHeader, line) || candidateComment.MatchString(line)
+}
+
+// WriteSecretsFile writes candidates as a secrets file, each preceded by a
+// comment saying where it was found, for review before a rewrite.
+func WriteSecretsFile(w io.Writer, candidates []Candidate) error {
+	if _, err := fmt.Fprintln(w, strings.Join(secretsFileHeader, "\n")); err != nil {
+		return err
+	}
+	for _, c := range candidates {
+		context := fmt.Sprintf("# %s %s:%d %s entropy %.2f", c.Commit, pathEscaper.Replace(c.Path), c.Line, c.Charset, c.Entropy)
+		if c.Keyword != "" {
+			context += fmt.Sprintf(" keyword %q", c.Keyword)
+		}
+		if c.HighConfidence {
+			context += " high-confidence"
+		}
+		if _, err := fmt.Fprintf(w, "\n%s\n%s\n", context, c.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package replacer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// encoders turn a secret into the forms it is commonly stored in. Each
+// encoding may produce several variants, such as padded and unpadded base64,
+// and a match of one variant is replaced with the same variant of the
+// replacement.
+var encoders = map[string]func(string) []string{
+	"base64": func(s string) []string {
+		return []string{base64.StdEncoding.EncodeToString([]byte(s)), base64.RawStdEncoding.EncodeToString([]byte(s))}
+	},
+	"base64url": func(s string) []string {
+		return []string{base64.URLEncoding.EncodeToString([]byte(s)), base64.RawURLEncoding.EncodeToString([]byte(s))}
+	},
+	"url": func(s string) []string {
+		return []string{url.QueryEscape(s), url.PathEscape(s)}
+	},
+	"json": func(s string) []string {
+		return []string{jsonEscape(s, false), jsonEscape(s, true)}
+	},
+	"hex": func(s string) []string {
+		return []string{hex.EncodeToString([]byte(s)), strings.ToUpper(hex.EncodeToString([]byte(s)))}
+	},
+}
+
+// Encodings lists the encodings ParseEncodings accepts, in the order "all"
+// expands to.
+var Encodings = []string{"base64", "base64url", "url", "json", "hex"}
+
+// jsonEscape returns s as it appears inside a JSON string, without quotes.
+func jsonEscape(s string, escapeHTML bool) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(escapeHTML)
+	encoder.Encode(s)
+	return strings.TrimSuffix(strings.TrimSuffix(buf.String(), "\n"), `"`)[1:]
+}
+
+// ParseEncodings parses a comma separated list of encodings, where "all"
+// stands for every encoding.
+func ParseEncodings(list string) ([]string, error) {
+	var encodings []string
+	for _, encoding := range strings.Split(list, ",") {
+		encoding = strings.ToLower(strings.TrimSpace(encoding))
+		switch {
+		case encoding == "":
+		case encoding == "all":
+			encodings = append(encodings, Encodings...)
+		case encoders[encoding] != nil:
+			encodings = append(encodings, encoding)
+		default:
+			return nil, fmt.Errorf("unknown encoding %q (expected %s or all)", encoding, strings.Join(Encodings, ", "))
+		}
+	}
+	return encodings, nil
+}
+
+// WithEncodedVariants returns secrets followed by the variants of every
+// literal secret in the given encodings. A variant keeps the name, line and
+// replacement of its secret and records the encoding and original value, so
+// that it is reported as the original secret and replaced with the encoded
+// replacement. Variants equal to the original or to another variant of the
+// same secret are left out.
+func WithEncodedVariants(secrets []Secret, encodings []string) []Secret {
+	result := append([]Secret(nil), secrets...)
+	for _, secret := range secrets {
+		if secret.Regex != nil {
+			continue
+		}
+		seen := map[string]bool{secret.Value: true}
+		for _, encoding := range encodings {
+			for i, variant := range encoders[encoding](secret.Value) {
+				if seen[variant] {
+					continue
+				}
+				seen[variant] = true
+
+				encoded := secret
+				encoded.Value = variant
+				encoded.Encoding = encoding
+				encoded.Original = secret.Value
+				encoded.variant = i
+				result = append(result, encoded)
+			}
+		}
+	}
+	return result
+}
+
+// encodeReplacement returns replacement encoded like the variant secret is,
+// or unchanged for secrets that are not encoded variants.
+func encodeReplacement(secret Secret, replacement string) string {
+	if secret.Encoding == "" {
+		return replacement
+	}
+	return encoders[secret.Encoding](replacement)[secret.variant]
+}
@@ -0,0 +1,73 @@
+package replacer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseEncodings(t *testing.T) {
+	encodings, err := ParseEncodings("base64, HEX,,")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(encodings, []string{"base64", "hex"}) {
+		t.Errorf("unexpected encodings %v", encodings)
+	}
+
+	if encodings, _ := ParseEncodings("all"); !reflect.DeepEqual(encodings, Encodings) {
+		t.Errorf("expected all to expand to every encoding, got %v", encodings)
+	}
+	if _, err := ParseEncodings("base64,rot13"); err == nil {
+		t.Error("expected an unknown encoding to be rejected")
+	}
+}
+
+func TestWithEncodedVariants(t *testing.T) {
+	secrets := WithEncodedVariants([]Secret{{Name: "db", Value: "p@ss word"}, {Value: "abc"}}, Encodings)
+
+	variants := make(map[string]string)
+	for _, secret := range secrets[2:] {
+		if secret.Original == "" || secret.Line != 0 {
+			t.Errorf("unexpected variant %+v", secret)
+		}
+		variants[secret.Value] = secret.Encoding
+	}
+
+	for value, encoding := range map[string]string{
+		"cEBzcyB3b3Jk":       "base64",
+		"p%40ss+word":        "url",
+		"p@ss%20word":        "url",
+		"7040737320776f7264": "hex",
+		"7040737320776F7264": "hex",
+		"YWJj":               "base64",
+		"616263":             "hex",
+	} {
+		if variants[value] != encoding {
+			t.Errorf("expected %s variant %q, got %v", encoding, value, variants)
+		}
+	}
+	if _, found := variants["abc"]; found {
+		t.Error("expected variants equal to the original to be left out")
+	}
+}
+
+func TestMatcherEncodedVariants(t *testing.T) {
+	m := NewSecretMatcher(WithEncodedVariants([]Secret{{Name: "db", Value: "hunter2"}, {Value: "s3cr3t!"}}, Encodings))
+
+	content := []byte("password: aHVudGVyMg==\nurl: x?p=s3cr3t%21\n")
+	got, matches := m.Replace(content, "**REMOVED**")
+
+	expected := "password: KipSRU1PVkVEOmRiKio=\nurl: x?p=%2A%2AREMOVED%2A%2A\n"
+	if string(got) != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+	if len(matches) != 2 {
+		t.Fatalf("expected 2 matches, got %v", matches)
+	}
+	if label := m.label(matches[0].Pattern, content[matches[0].Start:matches[0].End]); label != "db/base64" {
+		t.Errorf("expected a named base64 label, got %q", label)
+	}
+	if label := m.label(matches[1].Pattern, content[matches[1].Start:matches[1].End]); label != SecretID("s3cr3t!")+"/url" {
+		t.Errorf("expected the original secret's ID with the encoding, got %q", label)
+	}
+}
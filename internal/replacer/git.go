@@ -9,6 +9,29 @@ import (
 
 var CommitMap = make(map[string]string)
 
+// NotesRefPrefix is where git notes are kept. A notes ref is a history of
+// commits whose trees hold one note blob per annotated object.
+const NotesRefPrefix = "refs/notes/"
+
+// IsNotesRef reports whether ref is under NotesRefPrefix.
+func IsNotesRef(ref string) bool {
+	return strings.HasPrefix(ref, NotesRefPrefix)
+}
+
+// pushedRefPrefixes hold the refs that are force pushed. Remote-tracking refs
+// and the stash only make sense in the local repository.
+var pushedRefPrefixes = []string{"refs/heads/", "refs/tags/", NotesRefPrefix}
+
+// IsPushedRef reports whether ref is force pushed to origin.
+func IsPushedRef(ref string) bool {
+	for _, prefix := range pushedRefPrefixes {
+		if strings.HasPrefix(ref, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 func GetRefs() ([]string, error) {
 	output, err := exec.Command("git", "for-each-ref", "--format=%(refname)").Output()
 	if err != nil {
@@ -17,6 +40,24 @@ func GetRefs() ([]string, error) {
 	return strings.Split(strings.TrimSpace(string(output)), "\n"), nil
 }
 
+// ResolveRef returns the object ref points at and the type of the object it
+// peels to, which differ for annotated tags.
+func ResolveRef(ref string) (string, string, error) {
+	output, err := exec.Command("git", "rev-parse", ref, ref+"^{}").Output()
+	if err != nil {
+		return "", "", err
+	}
+	ids := strings.Fields(string(output))
+	if len(ids) != 2 {
+		return "", "", fmt.Errorf("unexpected rev-parse output for %s: %q", ref, output)
+	}
+	peeledType, _, err := Objects().Info(ids[1])
+	if err != nil {
+		return "", "", err
+	}
+	return ids[0], peeledType, nil
+}
+
 func GetCommits(ref string) ([]string, error) {
 	output, err := exec.Command("git", "rev-list", ref).Output()
 	if err != nil {
@@ -25,9 +66,9 @@ func GetCommits(ref string) ([]string, error) {
 	return strings.Split(strings.TrimSpace(string(output)), "\n"), nil
 }
 
-func UpdateRef(ref, newCommitHash string) error {
-	fmt.Printf("Updating ref %s to new commit hash %s\n", ref, newCommitHash)
-	err := exec.Command("git", "update-ref", ref, newCommitHash).Run()
+func UpdateRef(ref, newHash string) error {
+	fmt.Printf("Updating ref %s to new hash %s\n", ref, newHash)
+	err := exec.Command("git", "update-ref", ref, newHash).Run()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error updating ref %s: %v\n", ref, err)
 	}
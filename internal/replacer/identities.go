@@ -0,0 +1,161 @@
+package replacer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Mailmap maps the names and emails found in commits and tags to the ones to
+// write instead, read from a file in the format of git's .mailmap.
+type Mailmap struct {
+	entries []mailmapEntry
+}
+
+// mailmapEntry replaces the identity with commitEmail, and commitName when it
+// is set, with the proper name and email. An empty proper field is kept.
+type mailmapEntry struct {
+	properName  string
+	properEmail string
+	commitName  string
+	commitEmail string
+}
+
+// Identities is the mailmap applied to author, committer and tagger lines.
+var Identities *Mailmap
+
+// RedactIdentities makes the secrets matcher also replace secrets in the names
+// and emails of authors, committers and taggers.
+var RedactIdentities bool
+
+// identityEscaper removes what cannot appear in a name or email, in case a
+// replacement holds it.
+var identityEscaper = strings.NewReplacer("<", "", ">", "", "\n", "")
+
+// ReadMailmap reads a mailmap file, skipping blank lines and comments. Each
+// line is one of:
+//
+//	Proper Name <commit@email>
+//	<proper@email> <commit@email>
+//	Proper Name <proper@email> <commit@email>
+//	Proper Name <proper@email> Commit Name <commit@email>
+func ReadMailmap(filePath string) (*Mailmap, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	m := &Mailmap{}
+	var errs []error
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, commentPrefix) {
+			continue
+		}
+		entry, err := parseMailmapLine(line)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s:%d: %w", filePath, lineNo, err))
+			continue
+		}
+		m.entries = append(m.entries, entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return m, nil
+}
+
+func parseMailmapLine(line string) (mailmapEntry, error) {
+	name1, email1, rest, found := cutIdentity(line)
+	if !found {
+		return mailmapEntry{}, errors.New("missing <email>")
+	}
+	name2, email2, _, found := cutIdentity(rest)
+	if !found {
+		return mailmapEntry{properName: name1, commitEmail: email1}, nil
+	}
+	return mailmapEntry{properName: name1, properEmail: email1, commitName: name2, commitEmail: email2}, nil
+}
+
+// cutIdentity splits "Name <email> rest" into its parts.
+func cutIdentity(s string) (name, email, rest string, found bool) {
+	name, after, found := strings.Cut(s, "<")
+	if !found {
+		return "", "", "", false
+	}
+	email, rest, found = strings.Cut(after, ">")
+	return strings.TrimSpace(name), email, rest, found
+}
+
+// Map returns the identity to write in place of name and email. As in git,
+// entries with a commit name win over those with only an email, and both are
+// compared case-insensitively.
+func (m *Mailmap) Map(name, email string) (string, string) {
+	if m == nil {
+		return name, email
+	}
+	var match *mailmapEntry
+	for i := range m.entries {
+		entry := &m.entries[i]
+		if !strings.EqualFold(entry.commitEmail, email) {
+			continue
+		}
+		if entry.commitName != "" && strings.EqualFold(entry.commitName, name) {
+			match = entry
+			break
+		}
+		if entry.commitName == "" && match == nil {
+			match = entry
+		}
+	}
+
+	if match == nil {
+		return name, email
+	}
+	if match.properName != "" {
+		name = match.properName
+	}
+	if match.properEmail != "" {
+		email = match.properEmail
+	}
+	return name, email
+}
+
+// rewriteIdentity applies the mailmap and, when redact is set, the matcher to
+// the value of an author, committer or tagger line of object. The timestamp
+// and timezone after the email are kept exactly, and so is the whole value
+// when nothing changes.
+func rewriteIdentity(ident, field, object string, matcher *Matcher, redact bool) string {
+	gt := strings.LastIndexByte(ident, '>')
+	lt := strings.LastIndexByte(ident[:max(gt, 0)], '<')
+	if gt < 0 || lt < 0 {
+		return ident
+	}
+	name, email, when := strings.TrimSuffix(ident[:lt], " "), ident[lt+1:gt], ident[gt+1:]
+
+	newName, newEmail := Identities.Map(name, email)
+	if redact {
+		path := "(" + field + ")"
+		newName = redactIdentityPart(newName, path, object, matcher)
+		newEmail = redactIdentityPart(newEmail, path, object, matcher)
+	}
+
+	if newName == name && newEmail == email {
+		return ident
+	}
+	return identityEscaper.Replace(newName) + " <" + identityEscaper.Replace(newEmail) + ">" + when
+}
+
+func redactIdentityPart(part, path, object string, matcher *Matcher) string {
+	content := []byte(part)
+	matches := recordMatches(object, path, content, matcher.FindAll(content), matcher)
+	return string(matcher.ReplaceMatches(content, matches, Replacement))
+}
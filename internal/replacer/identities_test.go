@@ -0,0 +1,169 @@
+package replacer
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeMailmap(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), ".mailmap")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMailmap_Map(t *testing.T) {
+	m, err := ReadMailmap(writeMailmap(t, "# people\n"+
+		"Jane Doe <jane@private.example>\n"+
+		"<ops@example.com> <root@build-01.internal>\n"+
+		"Bot <bot@example.com> <ci@example.com>\n"+
+		"Sam <sam@example.com> Old Sam <SAM@old.example>\n"+
+		"Anyone <anyone@example.com> <sam@old.example>\n"))
+	if err != nil {
+		t.Fatalf("ReadMailmap() error = %v", err)
+	}
+
+	tests := []struct {
+		name, email         string
+		wantName, wantEmail string
+	}{
+		{"jd", "jane@private.example", "Jane Doe", "jane@private.example"},
+		{"root", "root@build-01.internal", "root", "ops@example.com"},
+		{"ci", "CI@example.com", "Bot", "bot@example.com"},
+		{"old sam", "sam@old.example", "Sam", "sam@example.com"},
+		{"Other", "sam@old.example", "Anyone", "anyone@example.com"},
+		{"Stranger", "stranger@example.com", "Stranger", "stranger@example.com"},
+	}
+	for _, tt := range tests {
+		name, email := m.Map(tt.name, tt.email)
+		if name != tt.wantName || email != tt.wantEmail {
+			t.Errorf("Map(%q, %q) = %q, %q, want %q, %q", tt.name, tt.email, name, email, tt.wantName, tt.wantEmail)
+		}
+	}
+
+	if name, email := (*Mailmap)(nil).Map("a", "b"); name != "a" || email != "b" {
+		t.Errorf("expected a nil mailmap to keep the identity, got %q, %q", name, email)
+	}
+}
+
+func TestReadMailmap_Invalid(t *testing.T) {
+	_, err := ReadMailmap(writeMailmap(t, "Jane Doe\n"))
+	if err == nil || !strings.Contains(err.Error(), ":1: missing <email>") {
+		t.Errorf("expected the line to be reported, got %v", err)
+	}
+}
+
+func TestRewriteIdentity(t *testing.T) {
+	defer func() { Identities, RedactIdentities = nil, false }()
+	RunReport = &Report{}
+	matcher := NewMatcher([]string{"hunter2"})
+	Identities = &Mailmap{entries: []mailmapEntry{{properName: "Jane Doe", properEmail: "jane@example.com", commitEmail: "jane@private.example"}}}
+
+	if got := rewriteIdentity("Jane<jane@private.example>  1112911993 +0530", "author", "c1", matcher, false); got != "Jane Doe <jane@example.com>  1112911993 +0530" {
+		t.Errorf("expected the timestamp and timezone to be kept exactly, got %q", got)
+	}
+	if got := rewriteIdentity("Bob  <bob@example.com> 1112911993 -0000", "author", "c1", matcher, true); got != "Bob  <bob@example.com> 1112911993 -0000" {
+		t.Errorf("expected an unchanged identity to be kept byte for byte, got %q", got)
+	}
+	if got := rewriteIdentity("malformed", "author", "c1", matcher, true); got != "malformed" {
+		t.Errorf("expected a malformed identity to be kept, got %q", got)
+	}
+
+	Replacement = "<gone>"
+	defer func() { Replacement = DefaultReplacement }()
+	if got := rewriteIdentity("hunter2 <hunter2@example.com> 1112911993 +0100", "committer", "c1", matcher, true); got != "gone <gone@example.com> 1112911993 +0100" {
+		t.Errorf("expected the secret to be replaced without breaking the identity, got %q", got)
+	}
+	if len(RunReport.Findings) != 1 || RunReport.Findings[0].Path != "(committer)" || RunReport.Findings[0].Blob != "c1" {
+		t.Errorf("expected one finding in the committer, got %+v", RunReport.Findings)
+	}
+}
+
+func TestProcessCommit_RewritesIdentities(t *testing.T) {
+	dir := useTestRepo(t)
+	commitFiles(t, dir, map[string]string{"clean.txt": "clean\n"}, "first", "--author", "hunter2 <jane@private.example>", "--date", "2005-04-07T22:13:13+0530")
+	runGit(t, dir, "", "tag", "-a", "v1", "-m", "release")
+	commit := runGit(t, dir, "", "rev-parse", "HEAD")
+	tag := runGit(t, dir, "", "rev-parse", "v1")
+	matcher := NewMatcher([]string{"hunter2"})
+
+	Identities = &Mailmap{entries: []mailmapEntry{{properEmail: "jane@example.com", commitEmail: "jane@private.example"}}}
+	newCommit, err := ProcessCommit(commit, matcher)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := FlushObjects(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := runGit(t, dir, "", "log", "-1", "--format=%an <%ae> %ad|%cn <%ce> %cd", "--date=raw", newCommit); got != "hunter2 <jane@example.com> 1112892193 +0530|"+runGit(t, dir, "", "log", "-1", "--format=%cn <%ce> %cd", "--date=raw", commit) {
+		t.Errorf("expected only the author email to change, got %q", got)
+	}
+	if changes := RunReport.ChangedCommits; len(changes) != 1 || !reflect.DeepEqual(changes[0].Changes, []string{"author"}) {
+		t.Errorf("expected the commit to be reported with a changed author, got %+v", changes)
+	}
+
+	RedactIdentities = true
+	CommitMap = make(map[string]string)
+	newCommit, err = ProcessCommit(commit, matcher)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	newTag, err := ProcessRef(tag, matcher)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := FlushObjects(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := runGit(t, dir, "", "log", "-1", "--format=%an <%ae> %ad", "--date=raw", newCommit); got != "**REMOVED** <jane@example.com> 1112892193 +0530" {
+		t.Errorf("expected the secret to be replaced in the author name, got %q", got)
+	}
+
+	oldTagger := strings.Split(runGit(t, dir, "", "cat-file", "-p", tag), "\n")[3]
+	newTagger := strings.Split(runGit(t, dir, "", "cat-file", "-p", newTag), "\n")[3]
+	if newTagger != oldTagger {
+		t.Errorf("expected the tagger %q to be kept, got %q", oldTagger, newTagger)
+	}
+}
+
+func TestProcessTag_RewritesTagger(t *testing.T) {
+	dir := useTestRepo(t)
+	runGit(t, dir, "", "config", "user.email", "hunter2@example.com")
+	commitFiles(t, dir, map[string]string{"clean.txt": "clean\n"}, "first")
+	runGit(t, dir, "", "tag", "-a", "v1", "-m", "release")
+	tag := runGit(t, dir, "", "rev-parse", "v1")
+	matcher := NewMatcher([]string{"hunter2"})
+
+	RedactIdentities = true
+	if _, err := ProcessCommit(runGit(t, dir, "", "rev-parse", "HEAD"), matcher); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	newTag, err := ProcessRef(tag, matcher)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := FlushObjects(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	oldTagger, _ := strings.CutPrefix(strings.Split(runGit(t, dir, "", "cat-file", "-p", tag), "\n")[3], "tagger Test <hunter2@example.com>")
+	newTagger, _ := strings.CutPrefix(strings.Split(runGit(t, dir, "", "cat-file", "-p", newTag), "\n")[3], "tagger Test <**REMOVED**@example.com>")
+	if newTagger != oldTagger || !strings.HasPrefix(oldTagger, " ") {
+		t.Errorf("expected the tagger email to be redacted with the date kept, got %q and %q", oldTagger, newTagger)
+	}
+
+	var taggerFindings int
+	for _, finding := range RunReport.Findings {
+		if finding.Path == "(tagger)" && finding.Commit == tag {
+			taggerFindings++
+		}
+	}
+	if taggerFindings != 1 {
+		t.Errorf("expected one finding in the tagger, got %+v", RunReport.Findings)
+	}
+}
@@ -0,0 +1,535 @@
+package replacer
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxRegexMatch bounds the length of a match of a regex with no fixed maximum
+// length, such as one ending in "+", when files are streamed in chunks.
+// Longer matches are only found in files small enough to be read at once.
+const maxRegexMatch = 4096
+
+// secretGroup names the part of a regex match that is the secret itself.
+const secretGroup = "secret"
+
+type Match struct {
+	Start   int
+	End     int
+	Pattern int
+}
+
+type acEdge struct {
+	b    byte
+	next int32
+}
+
+type acNode struct {
+	children []acEdge
+	fail     int32
+	out      int32
+	pattern  int32
+	depth    int32
+}
+
+// Matcher finds many literal patterns in a single pass using an Aho-Corasick
+// automaton, plus any regex secrets. Matches are reported leftmost-longest and
+// never overlap, so when one secret is a substring of another the longer one
+// wins wherever both start at the same position.
+type Matcher struct {
+	secrets []Secret
+	regexes []int
+	nodes   []acNode
+	maxLen  int
+	// hasGroups is set when a regex only matches its secret group, whose
+	// start can be up to maxLen bytes after the start of the whole match.
+	hasGroups bool
+}
+
+// NewMatcher returns a Matcher for literal patterns.
+func NewMatcher(patterns []string) *Matcher {
+	return NewSecretMatcher(Literals(patterns))
+}
+
+// NewSecretMatcher returns a Matcher for secrets read from a secrets file.
+// Match.Pattern is an index into secrets.
+func NewSecretMatcher(secrets []Secret) *Matcher {
+	m := &Matcher{secrets: secrets, nodes: []acNode{{out: -1, pattern: -1}}}
+	for i, secret := range secrets {
+		if secret.Regex != nil {
+			m.regexes = append(m.regexes, i)
+			m.maxLen = max(m.maxLen, regexMaxLen(secret.Regex))
+			m.hasGroups = m.hasGroups || secret.Regex.SubexpIndex(secretGroup) >= 0
+		} else if secret.Value != "" {
+			m.insert(secret.Value, int32(i))
+			m.maxLen = max(m.maxLen, len(secret.Value))
+			// Multi-line secrets are also found in files with CRLF line
+			// endings.
+			if strings.Contains(secret.Value, "\n") {
+				crlf := strings.ReplaceAll(secret.Value, "\n", "\r\n")
+				m.insert(crlf, int32(i))
+				m.maxLen = max(m.maxLen, len(crlf))
+			}
+		}
+	}
+	m.link()
+	return m
+}
+
+// regexMaxLen returns the longest match re can produce, bounded by
+// maxRegexMatch, plus one byte so that a match ending at a word boundary or
+// line end is only accepted once the byte after it is known.
+func regexMaxLen(re *regexp.Regexp) int {
+	parsed, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return maxRegexMatch + 1
+	}
+	width, bounded := syntaxMaxLen(parsed.Simplify())
+	if !bounded || width > maxRegexMatch {
+		width = maxRegexMatch
+	}
+	return width + 1
+}
+
+func syntaxMaxLen(re *syntax.Regexp) (int, bool) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return len(re.Rune) * utf8.UTFMax, true
+		}
+		width := 0
+		for _, r := range re.Rune {
+			width += utf8.RuneLen(r)
+		}
+		return width, true
+	case syntax.OpCharClass:
+		if len(re.Rune) == 0 {
+			return 0, true
+		}
+		return max(utf8.RuneLen(re.Rune[len(re.Rune)-1]), 1), true
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return utf8.UTFMax, true
+	case syntax.OpCapture, syntax.OpQuest:
+		return syntaxMaxLen(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Max < 0 {
+			return 0, false
+		}
+		width, bounded := syntaxMaxLen(re.Sub[0])
+		return width * re.Max, bounded
+	case syntax.OpStar, syntax.OpPlus:
+		return 0, false
+	case syntax.OpConcat, syntax.OpAlternate:
+		total := 0
+		for _, sub := range re.Sub {
+			width, bounded := syntaxMaxLen(sub)
+			if !bounded {
+				return 0, false
+			}
+			if re.Op == syntax.OpConcat {
+				total += width
+			} else {
+				total = max(total, width)
+			}
+		}
+		return total, true
+	default:
+		// Empty matches and assertions such as ^, $ and \b.
+		return 0, true
+	}
+}
+
+func (m *Matcher) child(state int32, b byte) int32 {
+	children := m.nodes[state].children
+	i := sort.Search(len(children), func(i int) bool { return children[i].b >= b })
+	if i < len(children) && children[i].b == b {
+		return children[i].next
+	}
+	return -1
+}
+
+func (m *Matcher) insert(pattern string, index int32) {
+	state := int32(0)
+	for i := 0; i < len(pattern); i++ {
+		next := m.child(state, pattern[i])
+		if next < 0 {
+			next = int32(len(m.nodes))
+			m.nodes = append(m.nodes, acNode{out: -1, pattern: -1, depth: m.nodes[state].depth + 1})
+			children := append(m.nodes[state].children, acEdge{b: pattern[i], next: next})
+			sort.Slice(children, func(a, b int) bool { return children[a].b < children[b].b })
+			m.nodes[state].children = children
+		}
+		state = next
+	}
+	if m.nodes[state].pattern < 0 {
+		m.nodes[state].pattern = index
+	}
+}
+
+// link computes the failure links and, for every node, the deepest node on its
+// failure chain that ends a pattern, i.e. the longest pattern ending there.
+func (m *Matcher) link() {
+	queue := []int32{0}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for _, edge := range m.nodes[state].children {
+			next := edge.next
+			fail := int32(0)
+			if state != 0 {
+				for f := m.nodes[state].fail; ; f = m.nodes[f].fail {
+					if target := m.child(f, edge.b); target >= 0 {
+						fail = target
+						break
+					}
+					if f == 0 {
+						break
+					}
+				}
+			}
+			m.nodes[next].fail = fail
+			if m.nodes[next].pattern >= 0 {
+				m.nodes[next].out = next
+			} else {
+				m.nodes[next].out = m.nodes[fail].out
+			}
+			queue = append(queue, next)
+		}
+	}
+}
+
+func (m *Matcher) step(state int32, b byte) int32 {
+	for {
+		if next := m.child(state, b); next >= 0 {
+			return next
+		}
+		if state == 0 {
+			return 0
+		}
+		state = m.nodes[state].fail
+	}
+}
+
+// FindAll returns the non-overlapping leftmost-longest matches in content. For
+// a regex with a group named "secret" only the group is matched, so that its
+// surroundings can be required without being replaced. Matches that fail the
+// offline validation of their secret are left out.
+func (m *Matcher) FindAll(content []byte) []Match {
+	return m.findAll(content, 0)
+}
+
+// findAll is FindAll for content[from:], with offsets relative to it. The
+// bytes before from are only context for regex assertions such as ^ and \b.
+func (m *Matcher) findAll(content []byte, from int) []Match {
+	text := content[from:]
+	matches := m.findLiterals(text)
+	if len(m.regexes) == 0 {
+		return matches
+	}
+
+	for _, i := range m.regexes {
+		re := m.secrets[i].Regex
+		group := re.SubexpIndex(secretGroup)
+		for _, loc := range findRegex(re, content, from, group) {
+			start, end := loc[0], loc[1]
+			if group >= 0 {
+				start, end = loc[2*group], loc[2*group+1]
+			}
+			if end > start && m.validation(i, text[start:end]) != Invalid {
+				matches = append(matches, Match{Start: start, End: end, Pattern: i})
+			}
+		}
+	}
+	sort.SliceStable(matches, func(a, b int) bool {
+		if matches[a].Start != matches[b].Start {
+			return matches[a].Start < matches[b].Start
+		}
+		return matches[a].End > matches[b].End
+	})
+
+	selected := matches[:0]
+	end := 0
+	for _, match := range matches {
+		if match.Start >= end {
+			selected = append(selected, match)
+			end = match.End
+		}
+	}
+	return selected
+}
+
+// findRegex returns the matches of re in content[from:], relative to it, with
+// the submatches when group is the index of the secret group. Matches starting
+// in the context before from are dropped, or with a group, matches whose group
+// starts there. Without a group, a match running past from can only come from
+// starting the search too early, so the search starts over after its first
+// byte.
+func findRegex(re *regexp.Regexp, content []byte, from, group int) [][]int {
+	for start := 0; ; {
+		var locs [][]int
+		if group >= 0 {
+			locs = re.FindAllSubmatchIndex(content[start:], -1)
+		} else {
+			locs = re.FindAllIndex(content[start:], -1)
+		}
+
+		offset := from - start
+		found := locs[:0]
+		crossing := -1
+		for _, loc := range locs {
+			if group >= 0 && loc[2*group] < offset {
+				continue
+			}
+			if group < 0 && loc[0] < offset {
+				if loc[1] > offset {
+					crossing = loc[0]
+				}
+				continue
+			}
+			for j := range loc {
+				if loc[j] >= 0 {
+					loc[j] -= offset
+				}
+			}
+			found = append(found, loc)
+		}
+		if crossing < 0 {
+			return found
+		}
+		start += crossing + 1
+	}
+}
+
+func (m *Matcher) findLiterals(content []byte) []Match {
+	var matches []Match
+	if len(m.nodes) == 1 {
+		return matches
+	}
+
+	state := int32(0)
+	pending := Match{Pattern: -1}
+	for i := 0; ; i++ {
+		if i == len(content) {
+			if pending.Pattern < 0 {
+				break
+			}
+			matches = append(matches, pending)
+			i = pending.End - 1
+			state = 0
+			pending = Match{Pattern: -1}
+			continue
+		}
+
+		state = m.step(state, content[i])
+		if out := m.nodes[state].out; out >= 0 {
+			start := i + 1 - int(m.nodes[out].depth)
+			if pending.Pattern < 0 || start < pending.Start || (start == pending.Start && i+1 > pending.End) {
+				pending = Match{Start: start, End: i + 1, Pattern: int(m.nodes[out].pattern)}
+			}
+		}
+
+		// Once no match in progress can start at or before the pending one,
+		// it is final. Scanning resumes right after it.
+		if pending.Pattern >= 0 && i+1-int(m.nodes[state].depth) > pending.Start {
+			matches = append(matches, pending)
+			i = pending.End - 1
+			state = 0
+			pending = Match{Pattern: -1}
+		}
+	}
+	return matches
+}
+
+// replacementFor returns the replacement of the secret at index. Secrets
+// without one of their own fall back to NamedReplacement when they are named
+// and to defaultReplacement otherwise. Encoded variants get the replacement in
+// the same encoding.
+func (m *Matcher) replacementFor(index int, defaultReplacement string) string {
+	secret := m.secrets[index]
+	switch {
+	case secret.HasReplacement:
+		return encodeReplacement(secret, secret.Replacement)
+	case secret.Name != "":
+		return encodeReplacement(secret, strings.ReplaceAll(NamedReplacement, "{name}", secret.Name))
+	default:
+		return encodeReplacement(secret, defaultReplacement)
+	}
+}
+
+// label identifies the secret at index in reports: its name, or a hash of the
+// matched value for unnamed secrets. Encoded variants are identified by the
+// original secret followed by the encoding.
+func (m *Matcher) label(index int, value []byte) string {
+	secret := m.secrets[index]
+	label := secret.Name
+	switch {
+	case label != "":
+	case secret.Encoding != "":
+		label = SecretID(secret.Original)
+	default:
+		label = SecretID(string(value))
+	}
+	if secret.Encoding != "" {
+		label += "/" + secret.Encoding
+	}
+	return label
+}
+
+// validation checks a match of the secret at index offline.
+func (m *Matcher) validation(index int, value []byte) Validation {
+	if validate := m.secrets[index].Validate; validate != nil {
+		return validate(string(value))
+	}
+	return Unchecked
+}
+
+// Replace returns content with every match replaced, along with the matches.
+// Secrets without a replacement of their own are replaced with replacement,
+// or NamedReplacement when they are named.
+func (m *Matcher) Replace(content []byte, replacement string) ([]byte, []Match) {
+	matches := m.FindAll(content)
+	if len(matches) == 0 {
+		return content, nil
+	}
+	return m.ReplaceMatches(content, matches, replacement), matches
+}
+
+// ReplaceMatches returns content with the given matches, as found by FindAll,
+// replaced.
+func (m *Matcher) ReplaceMatches(content []byte, matches []Match, replacement string) []byte {
+	if len(matches) == 0 {
+		return content
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(len(content))
+	last := 0
+	for _, match := range matches {
+		buf.Write(content[last:match.Start])
+		buf.WriteString(m.replacementFor(match.Pattern, replacement))
+		last = match.End
+	}
+	buf.Write(content[last:])
+	return buf.Bytes()
+}
+
+// StreamReplacer replaces matches in content that is written to it in chunks
+// and writes the result to an underlying writer. It holds back the last bytes
+// of each chunk, as many as the longest pattern, so matches spanning chunk
+// boundaries are found exactly as Replace would find them.
+type StreamReplacer struct {
+	matcher     *Matcher
+	replacement string
+	out         io.Writer
+	onMatch     func(match Match, line int, value []byte)
+	carry       []byte
+	// context is the number of bytes at the start of carry that were already
+	// processed and are only kept for the regex assertions after them.
+	context int
+	offset  int
+	line    int
+	written int64
+	// keep, when set, reports whether a match stays as it is. onMatch is
+	// still called for it.
+	keep func(value []byte) bool
+}
+
+// NewStreamReplacer returns a StreamReplacer writing to out. onMatch, if not
+// nil, is called for every match with offsets into the whole content, the line
+// the match starts on and the matched bytes, which are only valid during the
+// call.
+func (m *Matcher) NewStreamReplacer(out io.Writer, replacement string, onMatch func(match Match, line int, value []byte)) *StreamReplacer {
+	return &StreamReplacer{matcher: m, replacement: replacement, out: out, onMatch: onMatch, line: 1}
+}
+
+func (r *StreamReplacer) Write(p []byte) (int, error) {
+	r.carry = append(r.carry, p...)
+	if err := r.process(false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close processes the held back bytes. It does not close the underlying writer.
+func (r *StreamReplacer) Close() error {
+	return r.process(true)
+}
+
+// Written returns the number of bytes written to the underlying writer.
+func (r *StreamReplacer) Written() int64 {
+	return r.written
+}
+
+func (r *StreamReplacer) process(final bool) error {
+	buf := r.carry[r.context:]
+	// Every pattern starting at or before limit ends inside buf, so the
+	// matches up to there cannot change once more content arrives.
+	limit := len(buf)
+	if !final {
+		limit = len(buf) - r.matcher.maxLen
+	}
+
+	// processed is where the last match seen ends, kept or not, so that no
+	// match is carried over and seen again.
+	emitted, counted, processed := 0, 0, 0
+	for _, match := range r.matcher.findAll(r.carry, r.context) {
+		if match.Start > limit {
+			break
+		}
+		r.line += bytes.Count(buf[counted:match.Start], []byte("\n"))
+		counted, processed = match.Start, match.End
+		if r.onMatch != nil {
+			r.onMatch(Match{Start: r.offset + match.Start, End: r.offset + match.End, Pattern: match.Pattern}, r.line, buf[match.Start:match.End])
+		}
+		if r.keep != nil && r.keep(buf[match.Start:match.End]) {
+			continue
+		}
+		if err := r.emit(buf[emitted:match.Start]); err != nil {
+			return err
+		}
+		if err := r.emit([]byte(r.matcher.replacementFor(match.Pattern, r.replacement))); err != nil {
+			return err
+		}
+		emitted = match.End
+	}
+
+	cut := len(buf)
+	if !final {
+		// A secret group past limit may belong to a match starting up to
+		// maxLen bytes earlier, which has to stay in the buffer.
+		keep := limit + 1
+		if r.matcher.hasGroups {
+			keep -= r.matcher.maxLen
+		}
+		cut = min(max(processed, keep), len(buf))
+	}
+	if err := r.emit(buf[emitted:cut]); err != nil {
+		return err
+	}
+
+	r.line += bytes.Count(buf[counted:cut], []byte("\n"))
+	r.offset += cut
+	// Up to maxLen bytes before the cut stay as context for the regexes, so
+	// that a ^ or \b right after it is judged as in the whole content.
+	start := r.context + cut
+	if len(r.matcher.regexes) > 0 {
+		start = max(start-r.matcher.maxLen, 0)
+	}
+	r.context = r.context + cut - start
+	r.carry = append(r.carry[:0], r.carry[start:]...)
+	return nil
+}
+
+func (r *StreamReplacer) emit(p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+	n, err := r.out.Write(p)
+	r.written += int64(n)
+	return err
+}
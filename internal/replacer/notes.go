@@ -0,0 +1,165 @@
+package replacer
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// notesFanout is the length of the directory names git spreads the notes of
+// a large notes tree over, as in "ab/cdef...".
+const notesFanout = 2
+
+// processNotesTree rewrites the tree of a notes commit, where each note is a
+// blob named after the object it annotates. Notes of rewritten commits and
+// tags are moved to the new IDs with the same fanout. When redact is set,
+// notes are redacted whatever IncludePaths and ExcludePaths say, since their
+// names are object IDs rather than paths, and other entries like any file.
+func processNotesTree(tree string, matcher *Matcher, redact bool) (string, error) {
+	var entries []TreeEntry
+	if err := listNotes(tree, "", len(tree), &entries); err != nil {
+		return "", err
+	}
+
+	newEntries := make([]TreeEntry, 0, len(entries))
+	paths := make(map[string]bool, len(entries))
+	changed := false
+	for _, entry := range entries {
+		newEntry := entry
+		if redact {
+			var err error
+			if entry.Mode == "100644" || entry.Mode == "100755" {
+				newEntry.Sha, err = ProcessBlob(entry.Sha, entry.Name, matcher)
+			} else {
+				newEntry.Sha, err = processEntry(entry, entry.Name, matcher)
+			}
+			if err != nil {
+				return "", err
+			}
+		}
+		newEntry.Name = notePath(entry, len(tree))
+
+		// Two objects can only be rewritten to the same one when they end up
+		// identical, which leaves room for a single note.
+		if paths[newEntry.Name] {
+			fmt.Fprintf(Output, "Dropped note %s, which annotates the same rewritten object as another note\n", entry.Name)
+			changed = true
+			continue
+		}
+		paths[newEntry.Name] = true
+		changed = changed || newEntry != entry
+		newEntries = append(newEntries, newEntry)
+	}
+
+	if !changed {
+		return tree, nil
+	}
+	return writeTreePaths(newEntries)
+}
+
+// listNotes appends the entries of the notes tree under dir to entries, named
+// by their path. Only fanout directories are descended into.
+func listNotes(tree, dir string, idLen int, entries *[]TreeEntry) error {
+	obj, err := Objects().Read(tree)
+	if err != nil {
+		return fmt.Errorf("error getting tree content for %s: %w", tree, err)
+	}
+	parsed, err := ParseTree(obj.Content, len(tree)/2)
+	if err != nil {
+		return fmt.Errorf("error parsing tree %s: %w", tree, err)
+	}
+
+	prefix := strings.ReplaceAll(dir, "/", "")
+	for _, entry := range parsed {
+		name := entry.Name
+		if dir != "" {
+			entry.Name = dir + "/" + name
+		}
+		if entry.Mode == "40000" && len(name) == notesFanout && len(prefix)+notesFanout < idLen && isObjectID(name) {
+			if err := listNotes(entry.Sha, entry.Name, idLen, entries); err != nil {
+				return err
+			}
+			continue
+		}
+		*entries = append(*entries, entry)
+	}
+	return nil
+}
+
+// notePath returns the path entry moves to when it is the note of a
+// rewritten commit or tag, keeping its fanout, and its own path otherwise.
+func notePath(entry TreeEntry, idLen int) string {
+	id := strings.ReplaceAll(entry.Name, "/", "")
+	if entry.Mode == "40000" || len(id) != idLen || !isObjectID(id) {
+		return entry.Name
+	}
+	newID, found := CommitMap[id]
+	if !found {
+		newID, found = TagMap[id]
+	}
+	if !found || newID == id {
+		return entry.Name
+	}
+
+	dirs := strings.Count(entry.Name, "/")
+	var path strings.Builder
+	for i := 0; i < dirs; i++ {
+		path.WriteString(newID[i*notesFanout : (i+1)*notesFanout])
+		path.WriteByte('/')
+	}
+	path.WriteString(newID[dirs*notesFanout:])
+	return path.String()
+}
+
+// isObjectID reports whether s is written in lowercase hex, like the object
+// IDs git names notes after.
+func isObjectID(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil && strings.ToLower(s) == s
+}
+
+// writeTreePaths writes the trees holding entries, which are named by their
+// path, and returns the ID of the top one.
+func writeTreePaths(entries []TreeEntry) (string, error) {
+	var level []TreeEntry
+	subtrees := make(map[string][]TreeEntry)
+	for _, entry := range entries {
+		dir, rest, found := strings.Cut(entry.Name, "/")
+		if !found {
+			level = append(level, entry)
+			continue
+		}
+		if _, seen := subtrees[dir]; !seen {
+			level = append(level, TreeEntry{Mode: "40000", Name: dir})
+		}
+		entry.Name = rest
+		subtrees[dir] = append(subtrees[dir], entry)
+	}
+
+	for i, entry := range level {
+		if entry.Sha != "" {
+			continue
+		}
+		sha, err := writeTreePaths(subtrees[entry.Name])
+		if err != nil {
+			return "", err
+		}
+		level[i].Sha = sha
+	}
+
+	// Git sorts trees as if their names ended with a slash.
+	sortName := func(entry TreeEntry) string {
+		if entry.Mode == "40000" {
+			return entry.Name + "/"
+		}
+		return entry.Name
+	}
+	sort.Slice(level, func(i, j int) bool { return sortName(level[i]) < sortName(level[j]) })
+
+	newTree, err := WriteTree(level)
+	if err != nil {
+		return "", fmt.Errorf("error writing new tree: %w", err)
+	}
+	return newTree, nil
+}
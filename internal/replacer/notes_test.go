@@ -0,0 +1,99 @@
+package replacer
+
+import (
+	"strings"
+	"testing"
+)
+
+// notesRepo creates two commits, the first leaking a secret, with a note on
+// each, and returns the commits oldest first.
+func notesRepo(t *testing.T) (string, []string) {
+	t.Helper()
+	dir := useTestRepo(t)
+	commitFiles(t, dir, map[string]string{"config.txt": "password=hunter2\n"}, "first")
+	commitFiles(t, dir, map[string]string{"clean.txt": "clean\n"}, "second")
+	runGit(t, dir, "", "notes", "add", "-m", "deployed with hunter2", "HEAD~1")
+	runGit(t, dir, "", "notes", "add", "-m", "reviewed", "HEAD")
+	return dir, strings.Fields(runGit(t, dir, "", "rev-list", "--reverse", "HEAD"))
+}
+
+// rewriteWithNotes rewrites commits, then the notes, the way walkHistory
+// does, and points the notes ref at the result.
+func rewriteWithNotes(t *testing.T, dir string, commits []string, matcher *Matcher) {
+	t.Helper()
+	for _, commit := range commits {
+		if _, err := ProcessCommit(commit, matcher); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	var newNotes string
+	for _, commit := range strings.Fields(runGit(t, dir, "", "rev-list", "--reverse", "refs/notes/commits")) {
+		var err error
+		if newNotes, err = ProcessNotesCommit(commit, matcher); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := FlushObjects(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	runGit(t, dir, "", "update-ref", "refs/notes/commits", newNotes)
+}
+
+func checkNotes(t *testing.T, dir string, commits []string, want ...string) {
+	t.Helper()
+	for i, note := range want {
+		newCommit := CommitMap[commits[i]]
+		if newCommit == commits[i] {
+			t.Fatalf("expected commit %s to be rewritten", commits[i])
+		}
+		if got := runGit(t, dir, "", "notes", "show", newCommit); got != note {
+			t.Errorf("expected the note %q on the rewritten commit %s, got %q", note, newCommit, got)
+		}
+	}
+	if got := runGit(t, dir, "", "notes", "list"); strings.Contains(got, commits[0]) || strings.Contains(got, commits[1]) {
+		t.Errorf("expected no notes left on the original commits, got\n%s", got)
+	}
+}
+
+func TestProcessNotesCommit_MovesNotes(t *testing.T) {
+	dir, commits := notesRepo(t)
+	rewriteWithNotes(t, dir, commits, NewMatcher([]string{"hunter2"}))
+	checkNotes(t, dir, commits, "deployed with **REMOVED**", "reviewed")
+}
+
+func TestProcessNotesCommit_IgnoresPathFilters(t *testing.T) {
+	dir, commits := notesRepo(t)
+	IncludePaths = []string{"config.txt"}
+	rewriteWithNotes(t, dir, commits, NewMatcher([]string{"hunter2"}))
+	checkNotes(t, dir, commits, "deployed with **REMOVED**", "reviewed")
+}
+
+func TestProcessNotesCommit_NotesOff(t *testing.T) {
+	dir, commits := notesRepo(t)
+	RedactNotes = false
+	rewriteWithNotes(t, dir, commits, NewMatcher([]string{"hunter2"}))
+	checkNotes(t, dir, commits, "deployed with hunter2", "reviewed")
+}
+
+func TestNotePath(t *testing.T) {
+	defer func() { CommitMap = make(map[string]string) }()
+	old, rewritten := strings.Repeat("ab", 20), strings.Repeat("cd", 20)
+	CommitMap = map[string]string{old: rewritten}
+
+	tests := []struct {
+		entry TreeEntry
+		want  string
+	}{
+		{TreeEntry{Mode: "100644", Name: old}, rewritten},
+		{TreeEntry{Mode: "100644", Name: "ab/" + old[2:]}, "cd/" + rewritten[2:]},
+		{TreeEntry{Mode: "100644", Name: "ab/ab/" + old[4:]}, "cd/cd/" + rewritten[4:]},
+		{TreeEntry{Mode: "100644", Name: strings.Repeat("ef", 20)}, strings.Repeat("ef", 20)},
+		{TreeEntry{Mode: "40000", Name: old}, old},
+		{TreeEntry{Mode: "100644", Name: "README"}, "README"},
+	}
+	for _, tt := range tests {
+		if got := notePath(tt.entry, 40); got != tt.want {
+			t.Errorf("notePath(%+v) = %q, want %q", tt.entry, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package replacer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Object struct {
+	Sha     string
+	Type    string
+	Size    int64
+	Content []byte
+}
+
+// ObjectReader reads objects through long-running git cat-file --batch and
+// --batch-check processes instead of starting one process per object.
+type ObjectReader struct {
+	dir   string
+	mu    sync.Mutex
+	batch *catFile
+	check *catFile
+}
+
+type catFile struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+var defaultReader *ObjectReader
+var defaultReaderMu sync.Mutex
+
+func NewObjectReader(dir string) *ObjectReader {
+	return &ObjectReader{dir: dir}
+}
+
+// Objects returns the reader shared by the processing functions, which reads
+// from the repository in the current directory.
+func Objects() *ObjectReader {
+	defaultReaderMu.Lock()
+	defer defaultReaderMu.Unlock()
+	if defaultReader == nil {
+		defaultReader = NewObjectReader("")
+	}
+	return defaultReader
+}
+
+// CloseObjects stops the processes of the shared reader and drops anything
+// the shared writer has not flushed.
+func CloseObjects() error {
+	defaultWriterMu.Lock()
+	if defaultWriter != nil {
+		defaultWriter.Close()
+		defaultWriter = nil
+	}
+	defaultWriterMu.Unlock()
+
+	defaultReaderMu.Lock()
+	defer defaultReaderMu.Unlock()
+	if defaultReader == nil {
+		return nil
+	}
+	err := defaultReader.Close()
+	defaultReader = nil
+	return err
+}
+
+func startCatFile(dir, mode string) (*catFile, error) {
+	cmd := execCommand("git", "cat-file", mode)
+	cmd.Dir = dir
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("error starting git cat-file %s: %w", mode, err)
+	}
+	return &catFile{cmd: cmd, stdin: stdin, stdout: bufio.NewReaderSize(stdout, 64*1024)}, nil
+}
+
+func (c *catFile) close() error {
+	c.stdin.Close()
+	return c.cmd.Wait()
+}
+
+// request asks for sha and parses the "<sha> <type> <size>" header.
+func (c *catFile) request(sha string) (string, int64, error) {
+	if _, err := fmt.Fprintln(c.stdin, sha); err != nil {
+		return "", 0, fmt.Errorf("error requesting object %s: %w", sha, err)
+	}
+
+	header, err := c.stdout.ReadString('\n')
+	if err != nil {
+		return "", 0, fmt.Errorf("error reading object %s: %w", sha, err)
+	}
+
+	fields := strings.Fields(header)
+	if len(fields) == 2 && fields[1] == "missing" {
+		return "", 0, fmt.Errorf("object %s not found", sha)
+	}
+	if len(fields) != 3 {
+		return "", 0, fmt.Errorf("unexpected cat-file header for %s: %q", sha, header)
+	}
+
+	size, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("unexpected object size for %s: %w", sha, err)
+	}
+	return fields[1], size, nil
+}
+
+func (r *ObjectReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var err error
+	for _, c := range []*catFile{r.batch, r.check} {
+		if c != nil {
+			if closeErr := c.close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+	}
+	r.batch, r.check = nil, nil
+	return err
+}
+
+// Info returns the type and size of an object without reading its content.
+func (r *ObjectReader) Info(sha string) (string, int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.check == nil {
+		c, err := startCatFile(r.dir, "--batch-check")
+		if err != nil {
+			return "", 0, err
+		}
+		r.check = c
+	}
+	return r.check.request(sha)
+}
+
+// Stream passes the content of an object to fn without buffering it. fn must
+// not retain the reader after it returns.
+func (r *ObjectReader) Stream(sha string, fn func(objType string, size int64, content io.Reader) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.batch == nil {
+		c, err := startCatFile(r.dir, "--batch")
+		if err != nil {
+			return err
+		}
+		r.batch = c
+	}
+
+	objType, size, err := r.batch.request(sha)
+	if err != nil {
+		return err
+	}
+
+	content := io.LimitReader(r.batch.stdout, size)
+	fnErr := fn(objType, size, content)
+
+	// Drain whatever fn left unread, plus the trailing newline, so the next
+	// request starts at a header.
+	if _, err := io.Copy(io.Discard, content); err != nil {
+		return fmt.Errorf("error reading object %s: %w", sha, err)
+	}
+	if _, err := r.batch.stdout.Discard(1); err != nil {
+		return fmt.Errorf("error reading object %s: %w", sha, err)
+	}
+	return fnErr
+}
+
+func (r *ObjectReader) Read(sha string) (*Object, error) {
+	var obj *Object
+	err := r.Stream(sha, func(objType string, size int64, content io.Reader) error {
+		buf := bytes.NewBuffer(make([]byte, 0, size))
+		if _, err := io.Copy(buf, content); err != nil {
+			return fmt.Errorf("error reading object %s: %w", sha, err)
+		}
+		obj = &Object{Sha: sha, Type: objType, Size: size, Content: buf.Bytes()}
+		return nil
+	})
+	return obj, err
+}
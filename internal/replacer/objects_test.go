@@ -0,0 +1,191 @@
+package replacer
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func initTestRepo(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	runGit(t, dir, "", "init", "-q")
+	return dir
+}
+
+// useTestRepo points the shared reader and writer at a new repository, with a
+// committer identity set, and resets the per-run state.
+func useTestRepo(t *testing.T) string {
+	t.Helper()
+	execCommand = exec.Command
+	dir := initTestRepo(t)
+	runGit(t, dir, "", "config", "user.name", "Test")
+	runGit(t, dir, "", "config", "user.email", "test@example.com")
+
+	CloseObjects()
+	defaultReader = NewObjectReader(dir)
+	defaultWriter = NewObjectWriter(dir)
+	t.Cleanup(func() { CloseObjects() })
+
+	commitCache = NewObjectCache(DefaultCacheBudget)
+	treeCache = NewObjectCache(DefaultCacheBudget)
+	blobResultCache = sync.Map{}
+	treeResultCache = sync.Map{}
+	CommitMap = make(map[string]string)
+	TagMap = make(map[string]string)
+	RunReport = &Report{}
+	LargeBlobThreshold = DefaultLargeBlobThreshold
+	MaxBlobSize = 0
+	resetFilters := func() {
+		Ignore = nil
+		IncludePaths, ExcludePaths = nil, nil
+		RedactCommitMessages, RedactTagMessages, RedactNotes = true, true, true
+		Identities, RedactIdentities = nil, false
+	}
+	resetFilters()
+	t.Cleanup(resetFilters)
+	return dir
+}
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// commitFiles writes files, keyed by path, and commits everything with
+// message. args are passed on to git commit.
+func commitFiles(t *testing.T, dir string, files map[string]string, message string, args ...string) {
+	t.Helper()
+	for name, content := range files {
+		writeFile(t, dir, name, content)
+	}
+	runGit(t, dir, "", "add", "-A")
+	runGit(t, dir, "", append([]string{"commit", "-q", "-m", message}, args...)...)
+}
+
+func runGit(t *testing.T, dir, stdin string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(stdin)
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git %v: %v", args, err)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func TestObjectReader(t *testing.T) {
+	execCommand = exec.Command
+	dir := initTestRepo(t)
+	first := runGit(t, dir, "first blob\n", "hash-object", "-w", "--stdin")
+	second := runGit(t, dir, "second\n", "hash-object", "-w", "--stdin")
+
+	reader := NewObjectReader(dir)
+	defer reader.Close()
+
+	obj, err := reader.Read(first)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if obj.Type != "blob" || obj.Size != 11 || string(obj.Content) != "first blob\n" {
+		t.Errorf("unexpected object %+v", obj)
+	}
+
+	objType, size, err := reader.Info(second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if objType != "blob" || size != 7 {
+		t.Errorf("expected blob of size 7, got %s of size %d", objType, size)
+	}
+
+	// A partially consumed stream must not corrupt the next read.
+	err = reader.Stream(first, func(_ string, _ int64, content io.Reader) error {
+		_, err := content.Read(make([]byte, 3))
+		return err
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	obj, err = reader.Read(second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(obj.Content) != "second\n" {
+		t.Errorf("expected 'second', got %q", obj.Content)
+	}
+}
+
+func TestObjectReaderMissing(t *testing.T) {
+	execCommand = exec.Command
+	dir := initTestRepo(t)
+
+	reader := NewObjectReader(dir)
+	defer reader.Close()
+
+	if _, err := reader.Read(strings.Repeat("0", 40)); err == nil {
+		t.Error("expected an error for a missing object")
+	}
+	if _, _, err := reader.Info(strings.Repeat("0", 40)); err == nil {
+		t.Error("expected an error for a missing object")
+	}
+}
+
+func TestObjectWriter(t *testing.T) {
+	execCommand = exec.Command
+	dir := initTestRepo(t)
+
+	writer := NewObjectWriter(dir)
+	defer writer.Close()
+
+	content := []byte(strings.Repeat("large content ", 100))
+	blob, err := writer.Write("blob", content)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := runGit(t, dir, string(content), "hash-object", "--stdin"); blob != expected {
+		t.Errorf("expected blob %s, got %s", expected, blob)
+	}
+	if writer.Hash("blob", content) != blob {
+		t.Error("expected Hash to match the written object ID")
+	}
+
+	if again, err := writer.Write("blob", content); err != nil || again != blob {
+		t.Fatalf("expected duplicate write to return %s, got %s (%v)", blob, again, err)
+	}
+
+	treeContent, err := SerializeTree([]TreeEntry{{Mode: "100644", Name: "file.txt", Sha: blob}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tree, err := writer.Write("tree", treeContent)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := writer.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := runGit(t, dir, "", "cat-file", "-p", blob); got != strings.TrimSpace(string(content)) {
+		t.Errorf("unexpected blob content %q", got)
+	}
+	if got := runGit(t, dir, "", "ls-tree", "--name-only", tree); got != "file.txt" {
+		t.Errorf("unexpected tree content %q", got)
+	}
+	if packs := runGit(t, dir, "", "count-objects", "-v"); !strings.Contains(packs, "in-pack: 2") || !strings.Contains(packs, "count: 0") {
+		t.Errorf("expected two packed and no loose objects, got\n%s", packs)
+	}
+}
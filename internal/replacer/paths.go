@@ -0,0 +1,136 @@
+package replacer
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// IncludePaths, when not empty, limits scanning and rewriting to the files
+// matching one of its globs. Files matching one of ExcludePaths are left out
+// either way, and excluded directories are not even read.
+var IncludePaths []string
+var ExcludePaths []string
+
+// ParsePathGlobs parses a comma separated list of path globs.
+func ParsePathGlobs(list string) ([]string, error) {
+	var globs []string
+	for _, glob := range strings.Split(list, ",") {
+		if glob = strings.TrimSpace(glob); glob == "" {
+			continue
+		}
+		if err := checkGlob(glob); err != nil {
+			return nil, err
+		}
+		globs = append(globs, glob)
+	}
+	return globs, nil
+}
+
+func checkGlob(glob string) error {
+	if strings.Trim(glob, "/") == "" {
+		return errors.New("empty path")
+	}
+	for _, segment := range strings.Split(strings.Trim(glob, "/"), "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("invalid path %q: %w", glob, err)
+		}
+	}
+	return nil
+}
+
+// pathIncluded reports whether the file at filePath is scanned and rewritten.
+func pathIncluded(filePath string) bool {
+	if matchAny(ExcludePaths, filePath) {
+		return false
+	}
+	return len(IncludePaths) == 0 || matchAny(IncludePaths, filePath)
+}
+
+// dirIncluded reports whether the tree at dir may hold files that are
+// included, so that other trees are skipped without being read.
+func dirIncluded(dir string) bool {
+	if matchAny(ExcludePaths, dir) {
+		return false
+	}
+	if len(IncludePaths) == 0 {
+		return true
+	}
+	for _, glob := range IncludePaths {
+		if mayMatchUnder(glob, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathFiltered reports whether include or exclude globs are set, which makes
+// the outcome for a blob or tree depend on its path.
+func pathFiltered() bool {
+	return len(IncludePaths) > 0 || len(ExcludePaths) > 0
+}
+
+func matchAny(globs []string, filePath string) bool {
+	for _, glob := range globs {
+		if MatchGlob(glob, filePath) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchGlob reports whether the slash-separated filePath, or a directory it is
+// in, matches glob.
+func MatchGlob(glob, filePath string) bool {
+	segments := strings.Split(filePath, "/")
+	glob = strings.Trim(glob, "/")
+	if !strings.Contains(glob, "/") {
+		for _, segment := range segments {
+			if matched, _ := path.Match(glob, segment); matched {
+				return true
+			}
+		}
+		return false
+	}
+	return matchSegments(strings.Split(glob, "/"), segments)
+}
+
+func matchSegments(glob, segments []string) bool {
+	for ; len(glob) > 0; glob, segments = glob[1:], segments[1:] {
+		if glob[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(glob[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(glob[0], segments[0]); !matched {
+			return false
+		}
+	}
+	// Whatever is left is inside a matching directory.
+	return true
+}
+
+// mayMatchUnder reports whether glob can match dir or a path inside it.
+func mayMatchUnder(glob, dir string) bool {
+	glob = strings.Trim(glob, "/")
+	if !strings.Contains(glob, "/") {
+		return true
+	}
+	segments := strings.Split(dir, "/")
+	for globSegments := strings.Split(glob, "/"); len(globSegments) > 0 && len(segments) > 0; globSegments, segments = globSegments[1:], segments[1:] {
+		if globSegments[0] == "**" {
+			return true
+		}
+		if matched, _ := path.Match(globSegments[0], segments[0]); !matched {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package replacer
+
+import "testing"
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		glob     string
+		path     string
+		expected bool
+	}{
+		{"testdata", "pkg/testdata/key.pem", true},
+		{"*.pem", "certs/server.pem", true},
+		{"*.pem", "certs/server.pem.txt", false},
+		{"testdata/**", "testdata/a/b.txt", true},
+		{"docs/*.md", "docs/setup.md", true},
+		{"docs/*.md", "docs/api/setup.md", false},
+		{"docs/*.md", "src/docs/setup.md", false},
+		{"**/fixtures/*.json", "a/b/fixtures/x.json", true},
+		{"**/fixtures/*.json", "fixtures/x.json", true},
+		{"/config/dev", "config/dev/app.yml", true},
+	}
+
+	for _, tt := range tests {
+		if got := MatchGlob(tt.glob, tt.path); got != tt.expected {
+			t.Errorf("MatchGlob(%q, %q) = %v, expected %v", tt.glob, tt.path, got, tt.expected)
+		}
+	}
+}
+
+func TestParsePathGlobs(t *testing.T) {
+	globs, err := ParsePathGlobs("vendor/**, node_modules/**,,")
+	if err != nil || len(globs) != 2 || globs[1] != "node_modules/**" {
+		t.Errorf("unexpected globs %v, %v", globs, err)
+	}
+	if _, err := ParsePathGlobs("config/[a"); err == nil {
+		t.Error("expected an invalid glob to be rejected")
+	}
+}
+
+func TestPathFilters(t *testing.T) {
+	IncludePaths, ExcludePaths = []string{"config/**", "*.env"}, []string{"config/vendor"}
+	defer func() { IncludePaths, ExcludePaths = nil, nil }()
+
+	for dir, expected := range map[string]bool{"config": true, "config/app": true, "src": true, "config/vendor": false} {
+		if got := dirIncluded(dir); got != expected {
+			t.Errorf("dirIncluded(%q) = %v, expected %v", dir, got, expected)
+		}
+	}
+	for path, expected := range map[string]bool{"config/app.yml": true, "src/app.envrc": false, "src/prod.env": true, "src/main.go": false, "config/vendor/lib.yml": false} {
+		if got := pathIncluded(path); got != expected {
+			t.Errorf("pathIncluded(%q) = %v, expected %v", path, got, expected)
+		}
+	}
+
+	IncludePaths = []string{"config/**"}
+	if dirIncluded("src") {
+		t.Error("expected a tree outside the included paths to be skipped")
+	}
+}
+
+func TestProcessCommit_PathFilters(t *testing.T) {
+	dir := useTestRepo(t)
+	commitFiles(t, dir, map[string]string{
+		"config.txt":            "key=secret\n",
+		"vendor/lib/config.txt": "key=secret\n",
+	}, "first")
+	vendorTree := runGit(t, dir, "", "rev-parse", "HEAD:vendor")
+
+	ExcludePaths = []string{"vendor/**"}
+	newCommit, err := ProcessCommit(runGit(t, dir, "", "rev-parse", "HEAD"), NewMatcher([]string{"secret"}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := FlushObjects(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := runGit(t, dir, "", "cat-file", "-p", newCommit+":config.txt"); got != "key=**REMOVED**" {
+		t.Errorf("unexpected content %q", got)
+	}
+	if got := runGit(t, dir, "", "rev-parse", newCommit+":vendor"); got != vendorTree {
+		t.Errorf("expected the excluded tree to be kept as %s, got %s", vendorTree, got)
+	}
+	if len(RunReport.Findings) != 1 {
+		t.Errorf("expected no findings in excluded paths, got %+v", RunReport.Findings)
+	}
+}
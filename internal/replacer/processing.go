@@ -6,55 +6,104 @@ import (
 	"io"
 	"os"
 	"os/exec"
-	"regexp"
-	"runtime"
-	"strconv"
-	"strings"
+	"slices"
 	"sync"
 )
 
-var commitCache = sync.Map{}
-var treeCache = sync.Map{}
+// commitCache holds recently read commit objects and treeCache the tree ID of
+// recently seen commits. Blob and tree contents are read once per run thanks
+// to the result caches below, so they are never kept.
+var commitCache = NewObjectCache(DefaultCacheBudget - DefaultCacheBudget/8)
+var treeCache = NewObjectCache(DefaultCacheBudget / 8)
+
+// blobResultCache and treeResultCache map an original blob or tree ID to its
+// rewritten ID (the same ID when it was clean), so content shared between
+// commits and refs is only processed once per run. See resultKey.
+var blobResultCache = sync.Map{}
+var treeResultCache = sync.Map{}
 var execCommand = exec.Command
-var MemoryStatsWrapper = func(memStats *runtime.MemStats) {
-	runtime.ReadMemStats(memStats)
+
+// DryRun makes every write compute the object ID without storing the object,
+// so a full rewrite can be previewed without touching the repository.
+var DryRun bool
+
+// Output receives progress messages; scans set it to io.Discard.
+var Output io.Writer = os.Stdout
+
+// LargeBlobThreshold is the size in bytes above which a blob is streamed
+// through the matcher instead of being read into memory.
+var LargeBlobThreshold int64 = DefaultLargeBlobThreshold
+
+// Replacement is written in place of secrets that have no replacement of their
+// own in the secrets file.
+var Replacement = DefaultReplacement
+
+// NamedReplacement is the template written in place of named secrets that
+// have no replacement of their own, with {name} standing for the name.
+var NamedReplacement = DefaultNamedReplacement
+
+// RedactCommitMessages, RedactTagMessages and RedactNotes choose whether
+// secrets are also replaced in commit messages, annotated tag messages and the
+// notes under NotesRefPrefix. Notes are moved to the rewritten commits and
+// tags either way.
+var RedactCommitMessages = true
+var RedactTagMessages = true
+var RedactNotes = true
+
+// commitMessagePath stands in for the path of findings in commit messages.
+const commitMessagePath = "(commit message)"
+
+// MaxBlobSize, when positive, is the size in bytes above which blobs are left
+// as they are and recorded in RunReport as skipped.
+var MaxBlobSize int64
+
+// ResetRun forgets the results and report of an earlier walk over the
+// history, which only hold for the repository and secrets it ran with.
+func ResetRun() {
+	commitCache = NewObjectCache(DefaultCacheBudget - DefaultCacheBudget/8)
+	treeCache = NewObjectCache(DefaultCacheBudget / 8)
+	blobResultCache = sync.Map{}
+	treeResultCache = sync.Map{}
+	CommitMap = make(map[string]string)
+	TagMap = make(map[string]string)
+	RunReport = &Report{}
 }
 
-func GetCachedGitOutput(args ...string) ([]byte, error) {
-	key := strings.Join(args, " ")
-	if output, found := commitCache.Load(key); found {
-		return output.([]byte), nil
+// GetCachedObject reads an object through the shared ObjectReader, keeping
+// the result in commitCache for later calls.
+func GetCachedObject(sha string) (*Object, error) {
+	if obj, found := commitCache.Get(sha); found {
+		return obj.(*Object), nil
 	}
 
-	output, err := execCommand(args[0], args[1:]...).Output()
+	obj, err := Objects().Read(sha)
 	if err != nil {
 		return nil, err
 	}
 
-	commitCache.Store(key, output)
-	return output, nil
+	commitCache.Put(sha, obj, obj.Size)
+	return obj, nil
 }
 
 func GetTree(commit string) (string, error) {
-	if tree, found := treeCache.Load(commit); found {
+	if tree, found := treeCache.Get(commit); found {
 		return tree.(string), nil
 	}
 
-	output, err := GetCachedGitOutput("git", "cat-file", "-p", commit)
+	obj, err := GetCachedObject(commit)
 	if err != nil {
 		return "", err
 	}
 
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		if strings.HasPrefix(line, "tree ") {
-			tree := strings.Split(line, " ")[1]
-			treeCache.Store(commit, tree)
-			return tree, nil
-		}
+	parsed, err := ParseCommit(obj.Content)
+	if err != nil {
+		return "", fmt.Errorf("error parsing commit %s: %w", commit, err)
+	}
+	tree, found := parsed.Header("tree")
+	if !found {
+		return "", fmt.Errorf("commit %s has no tree", commit)
 	}
-	tree := commit + "123456"
-	treeCache.Store(commit, tree)
+	treeCache.Put(commit, tree, int64(len(tree)))
 	return tree, nil
 }
 
@@ -62,89 +111,64 @@ func IsBinary(content []byte) bool {
 	return bytes.IndexByte(content, 0) != -1
 }
 
-func isMemoryUsageHigh(commitSha string) (bool, error) {
-	var memStats runtime.MemStats
-	MemoryStatsWrapper(&memStats)
-	usedMemory := memStats.Alloc
-	totalMemory := memStats.Sys
+// resultKey is the key of the blob or tree id found at path in the result
+// caches. When path globs or allowlist entries for some paths are set, the
+// same object can be rewritten differently depending on where it is, so it is
+// cached per path.
+func resultKey(id, path string) string {
+	if Ignore.pathDependent() || pathFiltered() {
+		return id + "\x00" + path
+	}
+	return id
+}
 
-	output, err := GetCachedGitOutput("git", "cat-file", "-s", commitSha)
-	if err != nil {
-		return false, err
+func ProcessBlob(sha, path string, matcher *Matcher) (string, error) {
+	key := resultKey(sha, path)
+	if newSha, found := blobResultCache.Load(key); found {
+		return newSha.(string), nil
 	}
 
-	commitSize, err := strconv.ParseUint(strings.TrimSpace(string(output)), 10, 64)
+	newSha, err := processBlob(sha, path, matcher)
 	if err != nil {
-		return false, err
+		return "", err
 	}
 
-	usagePercentage := float64(usedMemory+commitSize) / float64(totalMemory) * 100
-	return usagePercentage > 90, nil
+	blobResultCache.Store(key, newSha)
+	return newSha, nil
 }
 
-func ProcessBlob(sha, path string, secrets []string) (string, error) {
-	isLargeBlob, err := isMemoryUsageHigh(strings.TrimSpace(string(sha)))
+func processBlob(sha, path string, matcher *Matcher) (string, error) {
+	_, size, err := Objects().Info(sha)
 	if err != nil {
 		return "", err
 	}
 
-	if isLargeBlob {
-		return ProcessLargeBlob(sha, path, secrets)
+	if MaxBlobSize > 0 && size > MaxBlobSize {
+		RunReport.addSkipped(path, sha, size)
+		fmt.Fprintf(Output, "Skipped file larger than the maximum blob size: %s (%d bytes)\n", path, size)
+		return sha, nil
+	}
+	if size > LargeBlobThreshold {
+		return ProcessLargeBlob(sha, path, matcher)
 	}
 
-	output, err := GetCachedGitOutput("git", "cat-file", "-p", sha)
+	obj, err := Objects().Read(sha)
 	if err != nil {
 		return "", err
 	}
 
+	output := obj.Content
 	if IsBinary(output) {
 		return sha, nil
 	}
 
-	content := string(output)
-	changed := false
-	var mu sync.Mutex
-
-	compiledRegexes := make([]*regexp.Regexp, len(secrets))
-	for i, secret := range secrets {
-		escapedRegex := regexp.QuoteMeta(secret)
-		compiledRegexes[i] = regexp.MustCompile(escapedRegex)
-	}
-
-	for pass := 1; pass <= 2; pass++ {
-		for _, regex := range compiledRegexes {
-			wg := sync.WaitGroup{}
-			numWorkers := runtime.NumCPU()
-			jobs := make(chan string, numWorkers)
-
-			for w := 0; w < numWorkers; w++ {
-				wg.Add(1)
-				go func() {
-					defer wg.Done()
-					for job := range jobs {
-						if regex.MatchString(job) {
-							mu.Lock()
-							content = regex.ReplaceAllString(content, "**REMOVED**")
-							changed = true
-							fmt.Println("Found and replaced sensitive string in file:", path)
-							mu.Unlock()
-						}
-					}
-				}()
-			}
-
-			jobs <- content
-			close(jobs)
-			wg.Wait()
-		}
-	}
-
-	if !changed {
+	matches := recordMatches(sha, path, output, matcher.FindAll(output), matcher)
+	if len(matches) == 0 {
 		return sha, nil
 	}
+	fmt.Fprintln(Output, "Found and replaced sensitive string in file:", path)
 
-	newContent := []byte(content)
-	newSha, err := WriteBlob(newContent)
+	newSha, err := WriteBlob(matcher.ReplaceMatches(output, matches, Replacement))
 	if err != nil {
 		return "", err
 	}
@@ -152,85 +176,109 @@ func ProcessBlob(sha, path string, secrets []string) (string, error) {
 	return newSha, nil
 }
 
-func ProcessLargeBlob(sha, path string, secrets []string) (string, error) {
-	tempFile, err := os.CreateTemp("", "processed_blob_*.txt")
-	if err != nil {
-		return "", err
-	}
-	defer tempFile.Close()
-
-	changed := false
-
-	compiledRegexes := make([]*regexp.Regexp, len(secrets))
-	for i, secret := range secrets {
-		escapedRegex := regexp.QuoteMeta(secret)
-		compiledRegexes[i] = regexp.MustCompile(escapedRegex)
-	}
-
-	chunkSize := 4096 // Read 4 KB at a time
-	readCmd := execCommand("git", "cat-file", "-p", sha)
-	stdout, err := readCmd.StdoutPipe()
-	if err != nil {
-		return "", err
-	}
-	if err := readCmd.Start(); err != nil {
-		return "", err
-	}
-
-	buf := make([]byte, chunkSize)
-	contentBuilder := strings.Builder{}
-
-	for {
-		n, err := stdout.Read(buf)
-		if err != nil && err != io.EOF {
-			return "", err
-		}
-		if n == 0 {
-			break
-		}
-
-		contentBuilder.Write(buf[:n])
-	}
-
-	content := contentBuilder.String()
-
-	for pass := 1; pass <= 2; pass++ {
-		for _, regex := range compiledRegexes {
-			if regex.MatchString(content) {
-				content = regex.ReplaceAllString(content, "**REMOVED**")
-				changed = true
-				fmt.Printf("Pass %d: Found and replaced sensitive string in file: %s\n", pass, path)
+// largeBlobChunkSize is how much of a large blob is held in memory at once.
+const largeBlobChunkSize = 64 * 1024
+
+// ProcessLargeBlob redacts a blob without holding it in memory. A first pass
+// streams the content through the matcher to find the matches and the size of
+// the result, a second pass, only needed when something matched, streams the
+// redacted content straight into the object writer.
+func ProcessLargeBlob(sha, path string, matcher *Matcher) (string, error) {
+	var findings []func()
+	var newSize int64
+	binary, replaced := false, false
+	keep := func(value []byte) bool { return Ignore.Allows(path, value) }
+
+	err := Objects().Stream(sha, func(_ string, _ int64, content io.Reader) error {
+		replacer := matcher.NewStreamReplacer(io.Discard, Replacement, func(match Match, line int, value []byte) {
+			label := matcher.label(match.Pattern, value)
+			if keep(value) {
+				findings = append(findings, func() { RunReport.addSuppressed(path, sha, line, label) })
+				return
+			}
+			replaced = true
+			validated := matcher.validation(match.Pattern, value) == Valid
+			findings = append(findings, func() { RunReport.addFinding(path, sha, line, label, validated) })
+		})
+		replacer.keep = keep
+
+		buf := make([]byte, largeBlobChunkSize)
+		for {
+			n, err := content.Read(buf)
+			if IsBinary(buf[:n]) {
+				binary = true
+				return nil
+			}
+			if _, writeErr := replacer.Write(buf[:n]); writeErr != nil {
+				return writeErr
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
 			}
 		}
-	}
-
-	if _, err := tempFile.Write([]byte(content)); err != nil {
-		return "", err
-	}
 
-	if err := readCmd.Wait(); err != nil {
+		if err := replacer.Close(); err != nil {
+			return err
+		}
+		newSize = replacer.Written()
+		return nil
+	})
+	if err != nil {
 		return "", err
 	}
 
-	if !changed {
+	if binary {
 		return sha, nil
 	}
-
-	newContent, err := os.ReadFile(tempFile.Name())
-	if err != nil {
-		return "", err
+	for _, record := range findings {
+		record()
 	}
-
-	newSha, err := WriteBlob(newContent)
-	if err != nil {
-		return "", err
+	if !replaced {
+		return sha, nil
 	}
+	fmt.Fprintf(Output, "Found and replaced sensitive string in file: %s\n", path)
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := Objects().Stream(sha, func(_ string, _ int64, content io.Reader) error {
+			replacer := matcher.NewStreamReplacer(pw, Replacement, nil)
+			replacer.keep = keep
+			if _, err := io.CopyBuffer(replacer, content, make([]byte, largeBlobChunkSize)); err != nil {
+				return err
+			}
+			return replacer.Close()
+		})
+		pw.CloseWithError(err)
+	}()
+	defer func() {
+		pr.Close()
+		<-done
+	}()
+
+	if DryRun {
+		return Writer().HashFrom("blob", newSize, pr)
+	}
+	return Writer().WriteFrom("blob", newSize, pr)
+}
 
-	os.Remove(tempFile.Name())
-	return newSha, nil
+func ProcessCommit(commit string, matcher *Matcher) (string, error) {
+	return processCommit(commit, matcher, false)
 }
 
-func ProcessCommit(commit string, secrets []string) (string, error) {
+// ProcessNotesCommit rewrites a commit of a notes ref like ProcessCommit, and
+// also moves each note to the rewritten ID of the object it annotates. Every
+// other ref must have been processed already, so that CommitMap and TagMap
+// are complete.
+func ProcessNotesCommit(commit string, matcher *Matcher) (string, error) {
+	return processCommit(commit, matcher, true)
+}
+
+func processCommit(commit string, matcher *Matcher, notes bool) (string, error) {
 	if newCommit, found := CommitMap[commit]; found {
 		return newCommit, nil
 	}
@@ -240,91 +288,127 @@ func ProcessCommit(commit string, secrets []string) (string, error) {
 		return "", fmt.Errorf("error getting tree for commit %s: %w", commit, err)
 	}
 
-	newTree, err := ProcessTree(tree, secrets)
+	RunReport.setCommit(commit)
+	newTree := tree
+	ignored := Ignore.IgnoresCommit(commit)
+	if ignored {
+		RunReport.addIgnoredCommit(commit)
+	}
+	if notes {
+		newTree, err = processNotesTree(tree, matcher, RedactNotes && !ignored)
+	} else if !ignored {
+		newTree, err = ProcessTree(tree, "", matcher)
+	}
 	if err != nil {
 		return "", fmt.Errorf("error processing tree %s: %w", tree, err)
 	}
 
-	output, err := GetCachedGitOutput("git", "cat-file", "-p", commit)
+	obj, err := GetCachedObject(commit)
 	if err != nil {
 		return "", fmt.Errorf("error getting commit content for %s: %w", commit, err)
 	}
 
-	var newCommit []string
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		if strings.HasPrefix(line, "tree ") {
-			newCommit = append(newCommit, fmt.Sprintf("tree %s", newTree))
-		} else if strings.HasPrefix(line, "parent ") {
-			parentHash := strings.Split(line, " ")[1]
-			if newParentHash, found := CommitMap[parentHash]; found {
-				newCommit = append(newCommit, fmt.Sprintf("parent %s", newParentHash))
-			} else {
-				newCommit = append(newCommit, line)
+	parsed, err := ParseCommit(obj.Content)
+	if err != nil {
+		return "", fmt.Errorf("error parsing commit %s: %w", commit, err)
+	}
+	var changes []string
+	if newTree != tree {
+		changes = append(changes, "tree")
+	}
+	for i, header := range parsed.Headers {
+		switch header.Key {
+		case "tree":
+			parsed.Headers[i].Value = newTree
+		case "parent":
+			if newParent, found := CommitMap[header.Value]; found && newParent != header.Value {
+				parsed.Headers[i].Value = newParent
+				changes = append(changes, "parents")
+			}
+		case "author", "committer":
+			ident := rewriteIdentity(header.Value, header.Key, commit, matcher, RedactIdentities && !ignored)
+			if ident != header.Value {
+				parsed.Headers[i].Value = ident
+				changes = append(changes, header.Key)
 			}
-		} else {
-			newCommit = append(newCommit, line)
 		}
 	}
 
-	cmd := execCommand("git", "hash-object", "-t", "commit", "-w", "--stdin")
-	cmd.Stdin = strings.NewReader(strings.Join(newCommit, "\n") + "\n")
-	newCommitHash, err := cmd.Output()
+	if RedactCommitMessages && !ignored {
+		matches := recordMatches(commit, commitMessagePath, parsed.Message, matcher.FindAll(parsed.Message), matcher)
+		if len(matches) > 0 {
+			parsed.Message = matcher.ReplaceMatches(parsed.Message, matches, Replacement)
+			changes = append(changes, "message")
+		}
+	}
+
+	content := parsed.Bytes()
+	if bytes.Equal(content, obj.Content) {
+		CommitMap[commit] = commit
+		return commit, nil
+	}
+	parsed.dropSignatures()
+	content = parsed.Bytes()
+
+	newCommitHashStr, err := writeObject("commit", content)
 	if err != nil {
 		return "", fmt.Errorf("error creating new commit object: %w", err)
 	}
 
-	newCommitHashStr := strings.TrimSpace(string(newCommitHash))
 	CommitMap[commit] = newCommitHashStr
-	fmt.Printf("Replaced old commit %s with new commit %s\n", commit, newCommitHashStr)
+	if newCommitHashStr != commit {
+		RunReport.addChangedCommit(commit, newCommitHashStr, slices.Compact(changes))
+	}
+	fmt.Fprintf(Output, "Replaced old commit %s with new commit %s\n", commit, newCommitHashStr)
 
 	return newCommitHashStr, nil
 }
 
-func ProcessTree(tree string, secrets []string) (string, error) {
-	output, err := GetCachedGitOutput("git", "cat-file", "-p", tree)
+func ProcessTree(tree, dir string, matcher *Matcher) (string, error) {
+	key := resultKey(tree, dir)
+	if newTree, found := treeResultCache.Load(key); found {
+		return newTree.(string), nil
+	}
+
+	newTree, err := processTree(tree, dir, matcher)
 	if err != nil {
-		return "", fmt.Errorf("error getting tree content for %s: %w", tree, err)
+		return "", err
 	}
 
-	var newEntries []string
-	changed := false
+	treeResultCache.Store(key, newTree)
+	return newTree, nil
+}
 
-	lines := strings.Split(string(output), "\n")
+func processTree(tree, dir string, matcher *Matcher) (string, error) {
+	obj, err := Objects().Read(tree)
+	if err != nil {
+		return "", fmt.Errorf("error getting tree content for %s: %w", tree, err)
+	}
 
-	for _, line := range lines {
-		if line == "" {
-			continue
-		}
+	entries, err := ParseTree(obj.Content, len(tree)/2)
+	if err != nil {
+		return "", fmt.Errorf("error parsing tree %s: %w", tree, err)
+	}
 
-		parts := strings.Split(line, "\t")
-		modeAndSha := parts[0]
-		path := parts[1]
+	newEntries := make([]TreeEntry, 0, len(entries))
+	changed := false
 
-		mode := strings.Split(modeAndSha, " ")[0]
-		sha := strings.Split(modeAndSha, " ")[2]
+	for _, entry := range entries {
+		fullPath := entry.Name
+		if dir != "" {
+			fullPath = dir + "/" + entry.Name
+		}
 
-		var newSha string
-		if mode == "040000" {
-			newSha, err = ProcessTree(sha, secrets)
-			if err != nil {
-				return "", fmt.Errorf("error processing subtree %s: %w", sha, err)
-			}
-			newEntries = append(newEntries, fmt.Sprintf("%s tree %s\t%s", mode, newSha, path))
-		} else if mode == "100644" || mode == "100755" {
-			newSha, err = ProcessBlob(sha, path, secrets)
-			if err != nil {
-				return "", fmt.Errorf("error processing blob %s: %w", sha, err)
-			}
-			newEntries = append(newEntries, fmt.Sprintf("%s blob %s\t%s", mode, newSha, path))
-		} else {
-			newSha = sha
-			newEntries = append(newEntries, fmt.Sprintf("%s %s\t%s", mode, newSha, path))
+		newEntry := entry
+		newEntry.Sha, err = processEntry(entry, fullPath, matcher)
+		if err != nil {
+			return "", err
 		}
 
-		if newSha != sha {
+		if newEntry.Sha != entry.Sha {
 			changed = true
 		}
+		newEntries = append(newEntries, newEntry)
 	}
 
 	if !changed {
@@ -339,24 +423,62 @@ func ProcessTree(tree string, secrets []string) (string, error) {
 	return newTree, nil
 }
 
-func WriteBlob(content []byte) (string, error) {
-	cmd := execCommand("git", "hash-object", "-w", "--stdin")
-	cmd.Stdin = bytes.NewReader(content)
-	output, err := cmd.Output()
-	if err != nil {
-		return "", err
+// processEntry returns the rewritten ID of the tree entry found at fullPath.
+func processEntry(entry TreeEntry, fullPath string, matcher *Matcher) (string, error) {
+	if entry.Mode == "40000" && dirIncluded(fullPath) {
+		newSha, err := ProcessTree(entry.Sha, fullPath, matcher)
+		if err != nil {
+			return "", fmt.Errorf("error processing subtree %s: %w", entry.Sha, err)
+		}
+		return newSha, nil
+	}
+	if (entry.Mode == "100644" || entry.Mode == "100755") && pathIncluded(fullPath) {
+		newSha, err := ProcessBlob(entry.Sha, fullPath, matcher)
+		if err != nil {
+			return "", fmt.Errorf("error processing blob %s: %w", entry.Sha, err)
+		}
+		return newSha, nil
 	}
+	return entry.Sha, nil
+}
 
-	return strings.TrimSpace(string(output)), nil
+// recordMatches records the matches in the blob sha at path and returns the
+// ones to replace. Matches the allowlist allows are recorded as suppressed.
+func recordMatches(sha, path string, content []byte, matches []Match, matcher *Matcher) []Match {
+	var replaced []Match
+	line, offset := 1, 0
+	for _, match := range matches {
+		line += bytes.Count(content[offset:match.Start], []byte("\n"))
+		offset = match.Start
+		value := content[match.Start:match.End]
+		if Ignore.Allows(path, value) {
+			RunReport.addSuppressed(path, sha, line, matcher.label(match.Pattern, value))
+			continue
+		}
+		RunReport.addFinding(path, sha, line, matcher.label(match.Pattern, value), matcher.validation(match.Pattern, value) == Valid)
+		replaced = append(replaced, match)
+	}
+	return replaced
+}
+
+func WriteBlob(content []byte) (string, error) {
+	return writeObject("blob", content)
 }
 
-func WriteTree(entries []string) (string, error) {
-	cmd := execCommand("git", "mktree")
-	cmd.Stdin = strings.NewReader(strings.Join(entries, "\n"))
-	output, err := cmd.CombinedOutput()
+func WriteTree(entries []TreeEntry) (string, error) {
+	content, err := SerializeTree(entries)
 	if err != nil {
 		return "", err
 	}
+	return writeObject("tree", content)
+}
 
-	return strings.TrimSpace(string(output)), nil
+// writeObject stores content as a new object of the given type through the
+// shared ObjectWriter and returns its ID. In DryRun mode the ID is only
+// computed.
+func writeObject(objType string, content []byte) (string, error) {
+	if DryRun {
+		return Writer().Hash(objType, content), nil
+	}
+	return Writer().Write(objType, content)
 }
@@ -1,13 +1,20 @@
 package replacer
 
 import (
+	"bufio"
+	"fmt"
 	"os"
 	"os/exec"
-	"runtime"
+	"reflect"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 )
 
+// redactedSha is the blob ID of "secret data\n" after redaction.
+const redactedSha = "14693eb8069061e7dfd22389f845d6671adabbfb"
+
 func mockExecCommand(command string, args ...string) *exec.Cmd {
 	cs := []string{"-test.run=TestHelperProcess", "--", command}
 	cs = append(cs, args...)
@@ -27,7 +34,9 @@ func TestHelperProcess(t *testing.T) {
 	}
 	switch args[3] {
 	case "git":
-		if len(args) > 4 && args[4] == "log" {
+		if len(args) > 5 && args[4] == "cat-file" && strings.HasPrefix(args[5], "--batch") {
+			serveCatFileBatch(args[5] == "--batch-check")
+		} else if len(args) > 4 && args[4] == "log" {
 			os.Stdout.Write([]byte("tree abcdef123456\n"))
 		} else if len(args) > 6 && args[4] == "cat-file" && args[5] == "-s" && args[6] == "abcdef" {
 			os.Stdout.Write([]byte("12345\n"))
@@ -47,38 +56,59 @@ func TestHelperProcess(t *testing.T) {
 	}
 }
 
-func TestGetCachedGitOutput_CacheHit(t *testing.T) {
-	commitCache = sync.Map{}
-	commitCache.Store("git log", []byte("abcdef123456"))
+// serveCatFileBatch emulates git cat-file --batch and --batch-check, where
+// "abcdef" holds "secret data\n" and every other object "mocked output\n".
+func serveCatFileBatch(checkOnly bool) {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		sha := scanner.Text()
+		content := "mocked output\n"
+		if sha == "abcdef" {
+			content = "secret data\n"
+		}
+
+		fmt.Printf("%s blob %d\n", sha, len(content))
+		if !checkOnly {
+			fmt.Printf("%s\n", content)
+		}
+	}
+}
+
+func TestGetCachedObject_CacheHit(t *testing.T) {
+	commitCache = NewObjectCache(DefaultCacheBudget)
+	commitCache.Put("abcdef", &Object{Sha: "abcdef", Type: "blob", Content: []byte("abcdef123456")}, 12)
 
-	output, err := GetCachedGitOutput("git", "log")
+	obj, err := GetCachedObject("abcdef")
 
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if string(output) != "abcdef123456" {
-		t.Errorf("expected 'abcdef123456', got '%s'", output)
+	if string(obj.Content) != "abcdef123456" {
+		t.Errorf("expected 'abcdef123456', got '%s'", obj.Content)
 	}
 }
 
-func TestGetCachedGitOutput_CacheMiss(t *testing.T) {
+func TestGetCachedObject_CacheMiss(t *testing.T) {
 	execCommand = mockExecCommand
-	defer func() { execCommand = exec.Command }() // Restore execCommand after the test
+	commitCache = NewObjectCache(DefaultCacheBudget)
+	defer CloseObjects()
 
-	output, err := GetCachedGitOutput("git", "log")
+	obj, err := GetCachedObject("abcdef")
 
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-
-	if string(output) != "abcdef123456" {
-		t.Errorf("expected 'abcdef123456', got '%s'", output)
+	if obj.Type != "blob" || string(obj.Content) != "secret data\n" {
+		t.Errorf("expected blob 'secret data', got %s '%s'", obj.Type, obj.Content)
+	}
+	if _, found := commitCache.Get("abcdef"); !found {
+		t.Error("expected object to be cached")
 	}
 }
 
 func TestGetTree_Found(t *testing.T) {
-	treeCache = sync.Map{}
-	treeCache.Store("abcdef", "123456")
+	treeCache = NewObjectCache(DefaultCacheBudget)
+	treeCache.Put("abcdef", "123456", 6)
 
 	tree, err := GetTree("abcdef")
 
@@ -92,15 +122,17 @@ func TestGetTree_Found(t *testing.T) {
 
 func TestGetTree_NotFound(t *testing.T) {
 	execCommand = mockExecCommand
+	treeCache = NewObjectCache(DefaultCacheBudget)
+	commitCache = NewObjectCache(DefaultCacheBudget)
+	defer CloseObjects()
+
 	tree, err := GetTree("abcdef")
 
-	if err != nil {
-		t.Fatalf("unexpected error: %v", err)
+	if err == nil || err.Error() != "commit abcdef has no tree" {
+		t.Fatalf("expected a missing tree error, got %q, %v", tree, err)
 	}
-
-	expectedTree := "123456"
-	if tree != expectedTree {
-		t.Errorf("expected '%s', got '%s'", expectedTree, tree)
+	if _, found := treeCache.Get("abcdef"); found {
+		t.Error("expected nothing to be cached for a commit without a tree")
 	}
 }
 
@@ -116,84 +148,259 @@ func TestIsBinary(t *testing.T) {
 	}
 }
 
-func TestIsMemoryUsageHigh_True(t *testing.T) {
-	MemoryStatsWrapper = func(memStats *runtime.MemStats) {
-		memStats.Alloc = 1
-		memStats.Sys = 1
+func TestProcessBlob_SmallBlob(t *testing.T) {
+	execCommand = mockExecCommand
+	defer CloseObjects()
+	sha, err := ProcessBlob("abcdef", "file.txt", NewMatcher([]string{"secret"}))
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sha != redactedSha {
+		t.Errorf("expected '%s', got '%s'", redactedSha, sha)
 	}
+}
 
+func TestProcessBlob_LargeBlob(t *testing.T) {
 	execCommand = mockExecCommand
-	high, err := isMemoryUsageHigh("abcdef")
+	LargeBlobThreshold = 4
+	blobResultCache = sync.Map{}
+	defer func() { LargeBlobThreshold = DefaultLargeBlobThreshold }()
+	defer CloseObjects()
+	sha, err := ProcessBlob("abcdef", "file.txt", NewMatcher([]string{"secret"}))
 
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if !high {
-		t.Error("expected high memory usage to be detected")
+	if sha != redactedSha {
+		t.Errorf("expected '%s', got '%s'", redactedSha, sha)
 	}
 }
 
-func TestIsMemoryUsageHigh_False(t *testing.T) {
-	MemoryStatsWrapper = func(memStats *runtime.MemStats) {
-		memStats.Alloc = 1024 * 1024 * 1024
-		memStats.Sys = 8 * 1024 * 1024 * 1024
+func TestProcessBlob_SkipsBlobsOverMaxSize(t *testing.T) {
+	execCommand = mockExecCommand
+	MaxBlobSize = 4
+	RunReport = &Report{}
+	blobResultCache = sync.Map{}
+	defer func() { MaxBlobSize = 0 }()
+	defer CloseObjects()
+
+	sha, err := ProcessBlob("abcdef", "big.bin", NewMatcher([]string{"secret"}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
 	}
+	if sha != "abcdef" {
+		t.Errorf("expected skipped blob to be left alone, got '%s'", sha)
+	}
+	if len(RunReport.Findings) != 0 {
+		t.Errorf("expected no findings for a skipped blob, got %+v", RunReport.Findings)
+	}
+	if len(RunReport.Skipped) != 1 || RunReport.Skipped[0].Path != "big.bin" || RunReport.Skipped[0].Size != int64(len("secret data\n")) {
+		t.Errorf("expected the blob to be recorded as skipped, got %+v", RunReport.Skipped)
+	}
+}
 
+func TestProcessLargeBlob_NoChanges(t *testing.T) {
 	execCommand = mockExecCommand
-	high, err := isMemoryUsageHigh("abcdef")
+	defer CloseObjects()
+	sha, err := ProcessLargeBlob("abcdef", "file.txt", NewMatcher(nil))
 
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if high {
-		t.Error("expected low memory usage not to be detected as high")
+	if sha != "abcdef" {
+		t.Errorf("expected 'abcdef', got '%s'", sha)
 	}
 }
 
-func TestProcessBlob_SmallBlob(t *testing.T) {
+func TestProcessLargeBlob_WithChanges(t *testing.T) {
 	execCommand = mockExecCommand
-	sha, err := ProcessBlob("abcdef", "file.txt", []string{"secret"})
+	defer CloseObjects()
+	sha, err := ProcessLargeBlob("abcdef", "file.txt", NewMatcher([]string{"secret"}))
 
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if sha != "newhash123" {
-		t.Errorf("expected 'newhash123', got '%s'", sha)
+	if sha != redactedSha {
+		t.Errorf("expected '%s', got '%s'", redactedSha, sha)
 	}
 }
 
-func TestProcessBlob_LargeBlob(t *testing.T) {
+func TestProcessBlob_DryRun(t *testing.T) {
 	execCommand = mockExecCommand
-	sha, err := ProcessBlob("abcdef", "file.txt", []string{"secret"})
+	DryRun = true
+	RunReport = &Report{}
+	blobResultCache = sync.Map{}
+	defer func() { DryRun = false }()
+	defer CloseObjects()
+
+	sha, err := ProcessBlob("abcdef", "dir/file.txt", NewMatcher([]string{"secret"}))
 
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if sha != "newhash123" {
-		t.Errorf("expected 'newhash123', got '%s'", sha)
+	if sha != redactedSha {
+		t.Errorf("expected '%s', got '%s'", redactedSha, sha)
+	}
+	if defaultWriter.pack != nil {
+		t.Error("expected nothing to be written in dry-run mode")
+	}
+	if len(RunReport.Findings) != 1 {
+		t.Fatalf("expected one finding, got %+v", RunReport.Findings)
+	}
+	finding := RunReport.Findings[0]
+	if finding.Path != "dir/file.txt" || finding.Line != 1 || finding.Secret != SecretID("secret") {
+		t.Errorf("unexpected finding %+v", finding)
 	}
 }
 
-func TestProcessLargeBlob_NoChanges(t *testing.T) {
-	execCommand = mockExecCommand
-	sha, err := ProcessLargeBlob("abcdef", "file.txt", []string{})
+func TestProcessBlob_Cached(t *testing.T) {
+	blobResultCache = sync.Map{}
+	blobResultCache.Store("cafe", "beef")
+
+	sha, err := ProcessBlob("cafe", "file.txt", NewMatcher([]string{"secret"}))
 
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if sha != "abcdef" {
-		t.Errorf("expected 'abcdef', got '%s'", sha)
+	if sha != "beef" {
+		t.Errorf("expected 'beef', got '%s'", sha)
 	}
 }
 
-func TestProcessLargeBlob_WithChanges(t *testing.T) {
-	execCommand = mockExecCommand
-	sha, err := ProcessLargeBlob("abcdef", "file.txt", []string{"secret"})
+func TestProcessTree_Cached(t *testing.T) {
+	treeResultCache = sync.Map{}
+	treeResultCache.Store("cafe", "beef")
+
+	tree, err := ProcessTree("cafe", "", NewMatcher([]string{"secret"}))
 
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if sha != "newhash123" {
-		t.Errorf("expected 'newhash123', got '%s'", sha)
+	if tree != "beef" {
+		t.Errorf("expected 'beef', got '%s'", tree)
+	}
+}
+
+func TestProcessCommit_SharedContentProcessedOnce(t *testing.T) {
+	dir := useTestRepo(t)
+	commitFiles(t, dir, map[string]string{"vendor/lib.txt": "key=secret\n"}, "first")
+	commitFiles(t, dir, map[string]string{"other.txt": "clean\n"}, "second")
+
+	matcher := NewMatcher([]string{"secret"})
+	var rewritten []string
+	for _, commit := range []string{"HEAD~1", "HEAD"} {
+		sha := runGit(t, dir, "", "rev-parse", commit)
+		newCommit, err := ProcessCommit(sha, matcher)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		rewritten = append(rewritten, newCommit)
+	}
+
+	if len(RunReport.Findings) != 1 {
+		t.Errorf("expected the shared blob to be reported once, got %+v", RunReport.Findings)
+	}
+
+	vendorTree := runGit(t, dir, "", "rev-parse", "HEAD:vendor")
+	newVendorTree, found := treeResultCache.Load(vendorTree)
+	if !found || newVendorTree == vendorTree {
+		t.Fatalf("expected the vendor tree to be cached as rewritten, got %v", newVendorTree)
+	}
+
+	if err := FlushObjects(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := runGit(t, dir, "", "rev-parse", rewritten[1]+":vendor"); got != newVendorTree {
+		t.Errorf("expected both commits to share vendor tree %s, got %s", newVendorTree, got)
+	}
+	if got := runGit(t, dir, "", "cat-file", "-p", rewritten[1]+":vendor/lib.txt"); got != "key=**REMOVED**" {
+		t.Errorf("unexpected content %q", got)
+	}
+}
+
+func TestProcessLargeBlob_SecretAcrossChunkBoundary(t *testing.T) {
+	dir := useTestRepo(t)
+	content := "line one\n" + strings.Repeat("a", largeBlobChunkSize-12) + "secret\n" + strings.Repeat("b", largeBlobChunkSize) + "secret"
+	blob := runGit(t, dir, content, "hash-object", "-w", "--stdin")
+
+	sha, err := ProcessLargeBlob(blob, "big.txt", NewMatcher([]string{"secret"}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := strings.ReplaceAll(content, "secret", "**REMOVED**")
+	if expectedSha := runGit(t, dir, expected, "hash-object", "--stdin"); sha != expectedSha {
+		t.Fatalf("expected %s, got %s", expectedSha, sha)
+	}
+	if len(RunReport.Findings) != 2 || RunReport.Findings[0].Line != 2 || RunReport.Findings[1].Line != 3 {
+		t.Errorf("expected findings on lines 2 and 3, got %+v", RunReport.Findings)
+	}
+
+	if err := FlushObjects(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := runGit(t, dir, "", "cat-file", "-s", sha); got != strconv.Itoa(len(expected)) {
+		t.Errorf("expected size %d, got %s", len(expected), got)
+	}
+}
+
+func TestProcessLargeBlob_Binary(t *testing.T) {
+	dir := useTestRepo(t)
+	blob := runGit(t, dir, "secret\x00binary", "hash-object", "-w", "--stdin")
+
+	sha, err := ProcessLargeBlob(blob, "image.png", NewMatcher([]string{"secret"}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sha != blob || len(RunReport.Findings) != 0 {
+		t.Errorf("expected binary blob to be left alone, got %s with %+v", sha, RunReport.Findings)
+	}
+}
+
+func TestProcessCommit_RedactsMessage(t *testing.T) {
+	dir := useTestRepo(t)
+	commitFiles(t, dir, map[string]string{"clean.txt": "clean\n"}, "first\n\ntemporarily hardcode key secret")
+	commit := runGit(t, dir, "", "rev-parse", "HEAD")
+	matcher := NewMatcher([]string{"secret"})
+
+	RedactCommitMessages = false
+	if newCommit, err := ProcessCommit(commit, matcher); err != nil || newCommit != commit {
+		t.Fatalf("expected the commit to be kept with messages turned off, got %s, %v", newCommit, err)
+	}
+
+	RedactCommitMessages = true
+	CommitMap = make(map[string]string)
+	newCommit, err := ProcessCommit(commit, matcher)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := FlushObjects(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := runGit(t, dir, "", "log", "-1", "--format=%B", newCommit); got != "first\n\ntemporarily hardcode key **REMOVED**" {
+		t.Errorf("unexpected message %q", got)
+	}
+	if len(RunReport.Findings) != 1 || RunReport.Findings[0].Path != commitMessagePath || RunReport.Findings[0].Line != 3 || RunReport.Findings[0].Commit != commit {
+		t.Errorf("expected a finding on line 3 of the message, got %+v", RunReport.Findings)
+	}
+	if changes := RunReport.ChangedCommits; len(changes) != 1 || changes[0].NewCommit != newCommit || !reflect.DeepEqual(changes[0].Changes, []string{"message"}) {
+		t.Errorf("expected the commit to be reported with a changed message, got %+v", changes)
+	}
+}
+
+func TestIsPushedRef(t *testing.T) {
+	for ref, want := range map[string]bool{
+		"refs/heads/main":            true,
+		"refs/tags/v1":               true,
+		"refs/notes/commits":         true,
+		"refs/remotes/origin/main":   false,
+		"refs/stash":                 false,
+		"refs/original/refs/heads/x": false,
+	} {
+		if got := IsPushedRef(ref); got != want {
+			t.Errorf("IsPushedRef(%q) = %v, want %v", ref, got, want)
+		}
 	}
 }
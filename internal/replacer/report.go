@@ -0,0 +1,230 @@
+package replacer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+type Finding struct {
+	Ref    string
+	Commit string
+	Path   string
+	Blob   string
+	Line   int
+	Secret string // the name of a named secret, otherwise its SecretID
+	// HighConfidence is set when the match passed the offline checksum or
+	// structure check of its token format.
+	HighConfidence bool
+}
+
+// SkippedBlob is a blob left unscanned because it exceeds MaxBlobSize.
+type SkippedBlob struct {
+	Ref    string
+	Commit string
+	Path   string
+	Blob   string
+	Size   int64
+}
+
+// CommitChange is a commit that is rewritten, with what changed in it:
+// "tree", "parents", "author", "committer" and/or "message".
+type CommitChange struct {
+	Commit    string
+	NewCommit string
+	Changes   []string
+}
+
+type RefUpdate struct {
+	Ref       string
+	OldCommit string
+	NewCommit string
+}
+
+// Report collects what a run found and changed, or would change in DryRun mode.
+// Each finding is recorded once, for the first ref and commit it was seen in.
+type Report struct {
+	mu       sync.Mutex
+	ref      string
+	commit   string
+	seen     map[string]bool
+	Findings []Finding
+	Skipped  []SkippedBlob
+	// Suppressed counts the matches left in place because the allowlist
+	// allows them, and IgnoredCommits the commits whose tree it ignores.
+	Suppressed     int
+	IgnoredCommits []string
+	ChangedCommits []CommitChange
+	RefUpdates     []RefUpdate
+}
+
+var RunReport = &Report{}
+
+// pathEscaper keeps paths holding tabs or line breaks on a single
+// tab-separated line of output.
+var pathEscaper = strings.NewReplacer("\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// SecretID identifies a secret in reports and logs without revealing its value.
+func SecretID(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return "sha256:" + hex.EncodeToString(sum[:4])
+}
+
+// SetRef starts attributing findings to ref, with no commit until one is
+// processed.
+func (r *Report) SetRef(ref string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ref = ref
+	r.commit = ""
+}
+
+func (r *Report) setCommit(commit string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.commit = commit
+}
+
+// addFinding records a secret, identified by label, found on line of path.
+func (r *Report) addFinding(path, blob string, line int, label string, highConfidence bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := fmt.Sprintf("%s\x00%s\x00%d\x00%s", blob, path, line, label)
+	if r.seen == nil {
+		r.seen = make(map[string]bool)
+	}
+	if r.seen[key] {
+		return
+	}
+	r.seen[key] = true
+
+	r.Findings = append(r.Findings, Finding{
+		Ref:    r.ref,
+		Commit: r.commit,
+		Path:   path,
+		Blob:   blob,
+		Line:   line,
+		Secret: label,
+
+		HighConfidence: highConfidence,
+	})
+}
+
+func (r *Report) addSkipped(path, blob string, size int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := fmt.Sprintf("skip\x00%s\x00%s", blob, path)
+	if r.seen == nil {
+		r.seen = make(map[string]bool)
+	}
+	if r.seen[key] {
+		return
+	}
+	r.seen[key] = true
+
+	r.Skipped = append(r.Skipped, SkippedBlob{Ref: r.ref, Commit: r.commit, Path: path, Blob: blob, Size: size})
+}
+
+// addSuppressed counts a match the allowlist allows, once per blob, path,
+// line and label like findings.
+func (r *Report) addSuppressed(path, blob string, line int, label string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := fmt.Sprintf("suppressed\x00%s\x00%s\x00%d\x00%s", blob, path, line, label)
+	if r.seen == nil {
+		r.seen = make(map[string]bool)
+	}
+	if r.seen[key] {
+		return
+	}
+	r.seen[key] = true
+	r.Suppressed++
+}
+
+func (r *Report) addIgnoredCommit(commit string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.IgnoredCommits = append(r.IgnoredCommits, commit)
+}
+
+func (r *Report) addChangedCommit(commit, newCommit string, changes []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ChangedCommits = append(r.ChangedCommits, CommitChange{Commit: commit, NewCommit: newCommit, Changes: changes})
+}
+
+func (r *Report) AddRefUpdate(ref, oldCommit, newCommit string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if oldCommit != newCommit {
+		r.RefUpdates = append(r.RefUpdates, RefUpdate{Ref: ref, OldCommit: oldCommit, NewCommit: newCommit})
+	}
+}
+
+// PrintFindings writes one tab-separated line per finding: ref, commit,
+// escaped path, line and secret identifier, followed by "high-confidence" for matches
+// that passed an offline check.
+func (r *Report) PrintFindings(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, f := range r.Findings {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s", f.Ref, f.Commit, pathEscaper.Replace(f.Path), f.Line, f.Secret)
+		if f.HighConfidence {
+			fmt.Fprint(w, "\thigh-confidence")
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// PrintSkipped writes one tab-separated line per skipped blob: ref, commit,
+// escaped path and size in bytes.
+func (r *Report) PrintSkipped(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, s := range r.Skipped {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", s.Ref, s.Commit, pathEscaper.Replace(s.Path), s.Size)
+	}
+}
+
+// PrintChangedCommits writes one line per rewritten commit: the old and new
+// IDs and what changed.
+func (r *Report) PrintChangedCommits(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.printChangedCommits(w)
+}
+
+func (r *Report) printChangedCommits(w io.Writer) {
+	for _, change := range r.ChangedCommits {
+		fmt.Fprintf(w, "  %s -> %s (%s)\n", change.Commit, change.NewCommit, strings.Join(change.Changes, ", "))
+	}
+}
+
+func (r *Report) Print(w io.Writer) {
+	r.PrintFindings(w)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	fmt.Fprintf(w, "Secrets found: %d\n", len(r.Findings))
+	fmt.Fprintf(w, "Findings suppressed by the ignore file: %d\n", r.Suppressed)
+	fmt.Fprintf(w, "Commits ignored: %d\n", len(r.IgnoredCommits))
+	fmt.Fprintf(w, "Files skipped: %d\n", len(r.Skipped))
+	for _, s := range r.Skipped {
+		fmt.Fprintf(w, "  %s %s (%d bytes)\n", s.Commit, pathEscaper.Replace(s.Path), s.Size)
+	}
+	fmt.Fprintf(w, "Commits to rewrite: %d\n", len(r.ChangedCommits))
+	r.printChangedCommits(w)
+	fmt.Fprintf(w, "Refs to update: %d\n", len(r.RefUpdates))
+	for _, update := range r.RefUpdates {
+		fmt.Fprintf(w, "  %s %s -> %s\n", update.Ref, update.OldCommit, update.NewCommit)
+	}
+}
@@ -0,0 +1,68 @@
+package replacer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRecordMatches(t *testing.T) {
+	RunReport = &Report{}
+	RunReport.SetRef("refs/heads/main")
+	RunReport.setCommit("abc123")
+
+	content := []byte("first line\npassword=hunter2\nthird\nhunter2 again\n")
+	matcher := NewMatcher([]string{"hunter2"})
+	recordMatches("blob1", "config.txt", content, matcher.FindAll(content), matcher)
+	recordMatches("blob1", "config.txt", content, matcher.FindAll(content), matcher)
+
+	if len(RunReport.Findings) != 2 {
+		t.Fatalf("expected 2 findings, got %+v", RunReport.Findings)
+	}
+	for i, line := range []int{2, 4} {
+		f := RunReport.Findings[i]
+		if f.Ref != "refs/heads/main" || f.Commit != "abc123" || f.Path != "config.txt" || f.Line != line {
+			t.Errorf("unexpected finding %+v", f)
+		}
+	}
+}
+
+func TestSecretIDDoesNotRevealSecret(t *testing.T) {
+	id := SecretID("hunter2")
+	if strings.Contains(id, "hunter2") {
+		t.Errorf("expected %q not to contain the secret", id)
+	}
+	if id != SecretID("hunter2") || id == SecretID("hunter3") {
+		t.Error("expected SecretID to be stable and distinct per secret")
+	}
+}
+
+func TestPrintFindings(t *testing.T) {
+	r := &Report{}
+	r.SetRef("refs/heads/main")
+	r.setCommit("abc123")
+	r.addFinding("dir/file.txt", "blob1", 7, SecretID("hunter2"), false)
+	r.addFinding("key.txt", "blob2", 1, "github-token", true)
+
+	var buf bytes.Buffer
+	r.PrintFindings(&buf)
+
+	expected := "refs/heads/main\tabc123\tdir/file.txt\t7\t" + SecretID("hunter2") + "\n" +
+		"refs/heads/main\tabc123\tkey.txt\t1\tgithub-token\thigh-confidence\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestPrintFindingsEscapesPaths(t *testing.T) {
+	r := &Report{}
+	r.addFinding("tab\there/new\nline", "blob1", 1, "db-password", false)
+
+	var buf bytes.Buffer
+	r.PrintFindings(&buf)
+
+	expected := "\t\ttab\\there/new\\nline\t1\tdb-password\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+}
@@ -0,0 +1,125 @@
+package replacer
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// TagMap maps an original annotated tag object to its rewritten ID, like
+// CommitMap does for commits.
+var TagMap = make(map[string]string)
+
+// tagMessagePath stands in for the path of findings in tag messages, and
+// taggedBlobPath for that of findings in a blob a tag points at directly.
+const tagMessagePath = "(tag message)"
+const taggedBlobPath = "(tagged blob)"
+
+// signatureMarkers start the signature appended to the message of a signed
+// tag.
+var signatureMarkers = [][]byte{
+	[]byte("-----BEGIN PGP SIGNATURE-----\n"),
+	[]byte("-----BEGIN SSH SIGNATURE-----\n"),
+	[]byte("-----BEGIN SIGNED MESSAGE-----\n"),
+}
+
+// ProcessRef returns the rewritten ID of the object a ref points at. Commits
+// must have gone through ProcessCommit oldest first already, so that their
+// parents are rewritten; annotated tags are rewritten as tags. Findings in a
+// tree or blob that is pointed at directly have its ID in place of a commit.
+func ProcessRef(sha string, matcher *Matcher) (string, error) {
+	objType, _, err := Objects().Info(sha)
+	if err != nil {
+		return "", err
+	}
+
+	switch objType {
+	case "commit":
+		return ProcessCommit(sha, matcher)
+	case "tag":
+		return ProcessTag(sha, matcher)
+	case "tree":
+		RunReport.setCommit(sha)
+		return ProcessTree(sha, "", matcher)
+	case "blob":
+		RunReport.setCommit(sha)
+		return ProcessBlob(sha, taggedBlobPath, matcher)
+	default:
+		return "", fmt.Errorf("unexpected object type %s for %s", objType, sha)
+	}
+}
+
+// ProcessTag writes a new tag object pointing at the rewritten target of tag,
+// which may itself be a tag, with secrets in the message replaced unless
+// RedactTagMessages is off, and the tagger rewritten like the author of a
+// commit. The rest of the header is kept. Like those of commits, the
+// signatures of a signed tag cannot survive a change and are dropped, whether
+// appended to the message or held in a header.
+func ProcessTag(tag string, matcher *Matcher) (string, error) {
+	if newTag, found := TagMap[tag]; found {
+		return newTag, nil
+	}
+
+	obj, err := Objects().Read(tag)
+	if err != nil {
+		return "", fmt.Errorf("error getting tag content for %s: %w", tag, err)
+	}
+	parsed, err := ParseCommit(obj.Content)
+	if err != nil {
+		return "", fmt.Errorf("error parsing tag %s: %w", tag, err)
+	}
+
+	target, found := parsed.Header("object")
+	if !found {
+		return "", fmt.Errorf("tag %s has no object", tag)
+	}
+	newTarget, err := ProcessRef(target, matcher)
+	if err != nil {
+		return "", fmt.Errorf("error processing object %s of tag %s: %w", target, tag, err)
+	}
+
+	RunReport.setCommit(tag)
+	for i, header := range parsed.Headers {
+		switch header.Key {
+		case "object":
+			parsed.Headers[i].Value = newTarget
+		case "tagger":
+			parsed.Headers[i].Value = rewriteIdentity(header.Value, header.Key, tag, matcher, RedactIdentities)
+		}
+	}
+
+	if RedactTagMessages {
+		if matches := recordMatches(tag, tagMessagePath, parsed.Message, matcher.FindAll(parsed.Message), matcher); len(matches) > 0 {
+			parsed.Message = matcher.ReplaceMatches(parsed.Message, matches, Replacement)
+		}
+	}
+
+	content := parsed.Bytes()
+	if bytes.Equal(content, obj.Content) {
+		TagMap[tag] = tag
+		return tag, nil
+	}
+	parsed.dropSignatures()
+	parsed.Message = stripSignature(parsed.Message)
+	content = parsed.Bytes()
+
+	newTag, err := writeObject("tag", content)
+	if err != nil {
+		return "", fmt.Errorf("error creating new tag object: %w", err)
+	}
+	TagMap[tag] = newTag
+	fmt.Fprintf(Output, "Replaced old tag %s with new tag %s\n", tag, newTag)
+	return newTag, nil
+}
+
+// stripSignature removes the signature from the end of a tag message.
+func stripSignature(message []byte) []byte {
+	for _, marker := range signatureMarkers {
+		if bytes.HasPrefix(message, marker) {
+			return message[:0]
+		}
+		if i := bytes.LastIndex(message, append([]byte("\n"), marker...)); i >= 0 {
+			return message[:i+1]
+		}
+	}
+	return message
+}
@@ -0,0 +1,158 @@
+package replacer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProcessTag_Nested(t *testing.T) {
+	dir := useTestRepo(t)
+	runGit(t, dir, "", "config", "advice.nestedTag", "false")
+	commitFiles(t, dir, map[string]string{"config.txt": "key=secret\n"}, "first")
+	runGit(t, dir, "", "tag", "-a", "v1", "-m", "release notes mention secret")
+	runGit(t, dir, "", "tag", "-a", "v1-outer", "v1", "-m", "outer")
+
+	matcher := NewMatcher([]string{"secret"})
+	commit := runGit(t, dir, "", "rev-parse", "HEAD")
+	newCommit, err := ProcessCommit(commit, matcher)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	outer := runGit(t, dir, "", "rev-parse", "v1-outer")
+	newOuter, err := ProcessRef(outer, matcher)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := FlushObjects(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := runGit(t, dir, "", "cat-file", "-t", newOuter); got != "tag" {
+		t.Fatalf("expected the outer tag to stay a tag, got %s", got)
+	}
+	innerContent := runGit(t, dir, "", "cat-file", "-p", TagMap[runGit(t, dir, "", "rev-parse", "v1")])
+	if !strings.HasPrefix(innerContent, "object "+newCommit+"\ntype commit\ntag v1\ntagger Test") {
+		t.Errorf("expected the inner tag to point at the rewritten commit, got %q", innerContent)
+	}
+	if !strings.HasSuffix(innerContent, "release notes mention **REMOVED**") {
+		t.Errorf("expected the tag message to be redacted, got %q", innerContent)
+	}
+	if got := runGit(t, dir, "", "rev-parse", newOuter+"^{commit}"); got != newCommit {
+		t.Errorf("expected the nested tags to peel to %s, got %s", newCommit, got)
+	}
+
+	var messageFindings int
+	for _, finding := range RunReport.Findings {
+		if finding.Path == tagMessagePath {
+			messageFindings++
+		}
+	}
+	if messageFindings != 1 {
+		t.Errorf("expected one finding in the tag message, got %+v", RunReport.Findings)
+	}
+}
+
+func TestProcessTag_Unchanged(t *testing.T) {
+	dir := useTestRepo(t)
+	commitFiles(t, dir, map[string]string{"clean.txt": "clean\n"}, "first")
+	runGit(t, dir, "", "tag", "-a", "v1", "-m", "clean")
+
+	matcher := NewMatcher([]string{"secret"})
+	if _, err := ProcessCommit(runGit(t, dir, "", "rev-parse", "HEAD"), matcher); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tag := runGit(t, dir, "", "rev-parse", "v1")
+	if newTag, err := ProcessRef(tag, matcher); err != nil || newTag != tag {
+		t.Errorf("expected a clean tag to be kept, got %s, %v", newTag, err)
+	}
+}
+
+func TestProcessRef_TaggedBlobAndTree(t *testing.T) {
+	dir := useTestRepo(t)
+	commitFiles(t, dir, map[string]string{"config.txt": "key=secret\n"}, "first")
+	blob := runGit(t, dir, "other secret\n", "hash-object", "-w", "--stdin")
+	tree := runGit(t, dir, "100644 blob "+runGit(t, dir, "third secret\n", "hash-object", "-w", "--stdin")+"\tthird.txt\n", "mktree")
+	matcher := NewMatcher([]string{"secret"})
+
+	RunReport.SetRef("refs/heads/main")
+	if _, err := ProcessCommit(runGit(t, dir, "", "rev-parse", "HEAD"), matcher); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for ref, target := range map[string]string{"refs/tags/blob": blob, "refs/tags/tree": tree} {
+		RunReport.SetRef(ref)
+		if _, err := ProcessRef(target, matcher); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	want := map[string]Finding{
+		"refs/tags/blob": {Commit: blob, Path: taggedBlobPath},
+		"refs/tags/tree": {Commit: tree, Path: "third.txt"},
+	}
+	for _, finding := range RunReport.Findings {
+		if w, found := want[finding.Ref]; found && (finding.Commit != w.Commit || finding.Path != w.Path) {
+			t.Errorf("expected the finding under %s in %s at %q, got %+v", finding.Ref, w.Commit, w.Path, finding)
+		}
+	}
+	if len(RunReport.Findings) != 3 {
+		t.Errorf("expected a finding in the commit, blob and tree, got %+v", RunReport.Findings)
+	}
+}
+
+func TestStripSignature(t *testing.T) {
+	signed := []byte("message\n-----BEGIN PGP SIGNATURE-----\n\nabc\n-----END PGP SIGNATURE-----\n")
+	if got := string(stripSignature(signed)); got != "message\n" {
+		t.Errorf("expected the signature to be removed, got %q", got)
+	}
+	if got := string(stripSignature([]byte("plain message\n"))); got != "plain message\n" {
+		t.Errorf("expected an unsigned message to be kept, got %q", got)
+	}
+}
+
+func TestProcessTag_MessagesTurnedOff(t *testing.T) {
+	dir := useTestRepo(t)
+	commitFiles(t, dir, map[string]string{"clean.txt": "clean\n"}, "first")
+	runGit(t, dir, "", "tag", "-a", "v1", "-m", "mentions secret")
+
+	RedactTagMessages = false
+	matcher := NewMatcher([]string{"secret"})
+	if _, err := ProcessCommit(runGit(t, dir, "", "rev-parse", "HEAD"), matcher); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tag := runGit(t, dir, "", "rev-parse", "v1")
+	if newTag, err := ProcessRef(tag, matcher); err != nil || newTag != tag || len(RunReport.Findings) != 0 {
+		t.Errorf("expected the tag message to be left alone, got %s, %v with %+v", newTag, err, RunReport.Findings)
+	}
+}
+
+func TestProcessTag_HeaderSignature(t *testing.T) {
+	dir := useTestRepo(t)
+	commitFiles(t, dir, map[string]string{"clean.txt": "clean\n"}, "first")
+	commit := runGit(t, dir, "", "rev-parse", "HEAD")
+
+	header := "object " + commit + "\ntype commit\ntag v1\ntagger Test <test@example.com> 1112911993 +0000\n" +
+		"gpgsig-sha256 -----BEGIN PGP SIGNATURE-----\n \n abc\n -----END PGP SIGNATURE-----\n"
+	signature := "-----BEGIN PGP SIGNATURE-----\n\ndef\n-----END PGP SIGNATURE-----\n"
+	tag := runGit(t, dir, header+"\nmentions secret\n"+signature, "hash-object", "-t", "tag", "-w", "--literally", "--stdin")
+	clean := runGit(t, dir, header+"\nclean\n"+signature, "hash-object", "-t", "tag", "-w", "--literally", "--stdin")
+
+	matcher := NewMatcher([]string{"secret"})
+	if _, err := ProcessCommit(commit, matcher); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if newTag, err := ProcessRef(clean, matcher); err != nil || newTag != clean {
+		t.Errorf("expected a clean signed tag to round-trip, got %s, %v", newTag, err)
+	}
+	newTag, err := ProcessRef(tag, matcher)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := FlushObjects(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "object " + commit + "\ntype commit\ntag v1\ntagger Test <test@example.com> 1112911993 +0000\n\nmentions **REMOVED**"
+	if got := runGit(t, dir, "", "cat-file", "tag", newTag); got != expected {
+		t.Errorf("expected both signatures to be dropped, got %q", got)
+	}
+}
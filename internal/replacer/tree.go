@@ -0,0 +1,59 @@
+package replacer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+)
+
+type TreeEntry struct {
+	Mode string
+	Name string
+	Sha  string
+}
+
+// ParseTree decodes the binary content of a tree object, where each entry is
+// "<mode> <name>\0" followed by the raw object ID of hashLen bytes.
+func ParseTree(content []byte, hashLen int) ([]TreeEntry, error) {
+	var entries []TreeEntry
+	for len(content) > 0 {
+		space := bytes.IndexByte(content, ' ')
+		if space < 0 {
+			return nil, fmt.Errorf("malformed tree entry: missing mode")
+		}
+		nul := bytes.IndexByte(content[space+1:], 0)
+		if nul < 0 {
+			return nil, fmt.Errorf("malformed tree entry: missing name terminator")
+		}
+		nameEnd := space + 1 + nul
+		if len(content) < nameEnd+1+hashLen {
+			return nil, fmt.Errorf("malformed tree entry: truncated object ID")
+		}
+
+		entries = append(entries, TreeEntry{
+			Mode: string(content[:space]),
+			Name: string(content[space+1 : nameEnd]),
+			Sha:  hex.EncodeToString(content[nameEnd+1 : nameEnd+1+hashLen]),
+		})
+		content = content[nameEnd+1+hashLen:]
+	}
+	return entries, nil
+}
+
+// SerializeTree encodes entries in the binary tree object format, keeping the
+// order they are given in.
+func SerializeTree(entries []TreeEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		sha, err := hex.DecodeString(entry.Sha)
+		if err != nil {
+			return nil, fmt.Errorf("malformed object ID %q for %s: %w", entry.Sha, entry.Name, err)
+		}
+		buf.WriteString(entry.Mode)
+		buf.WriteByte(' ')
+		buf.WriteString(entry.Name)
+		buf.WriteByte(0)
+		buf.Write(sha)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,142 @@
+package replacer
+
+import (
+	"fmt"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// unusualNames are file names that git quotes or escapes in its text output,
+// and that have to survive a rewrite byte for byte.
+var unusualNames = []string{
+	"plain.txt",
+	"with space.txt",
+	" leading space",
+	"trailing space ",
+	"tab\there",
+	"new\nline",
+	"carriage\rreturn",
+	`quote"d`,
+	`back\slash`,
+	"-leading-dash",
+	"control\x01char",
+	"\x7fdelete",
+	"ünïcödé.txt",
+	"日本語.txt",
+	"emoji-😀",
+	"invalid-utf8-\xff\xfe",
+	"octal-looking-\\303\\251",
+	strings.Repeat("n", 255),
+}
+
+func TestParseTreeRoundTrip(t *testing.T) {
+	content := "100644 file.txt\x00\x01\x23\x45\x67\x89\xab\xcd\xef\x01\x23\x45\x67\x89\xab\xcd\xef\x01\x23\x45\x67" +
+		"40000 sub dir\x00\x89\xab\xcd\xef\x01\x23\x45\x67\x89\xab\xcd\xef\x01\x23\x45\x67\x89\xab\xcd\xef"
+
+	entries, err := ParseTree([]byte(content), 20)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []TreeEntry{
+		{Mode: "100644", Name: "file.txt", Sha: "0123456789abcdef0123456789abcdef01234567"},
+		{Mode: "40000", Name: "sub dir", Sha: "89abcdef0123456789abcdef0123456789abcdef"},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, entries)
+	}
+
+	serialized, err := SerializeTree(entries)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(serialized) != content {
+		t.Errorf("expected %q, got %q", content, serialized)
+	}
+}
+
+func TestParseTreeMalformed(t *testing.T) {
+	for _, content := range []string{"100644", "100644 name", "100644 name\x00\x01\x02"} {
+		if _, err := ParseTree([]byte(content), 20); err == nil {
+			t.Errorf("expected an error for %q", content)
+		}
+	}
+
+	if _, err := SerializeTree([]TreeEntry{{Mode: "100644", Name: "a", Sha: "nothex"}}); err == nil {
+		t.Error("expected an error for a malformed object ID")
+	}
+}
+
+func TestParseTreeUnusualNames(t *testing.T) {
+	for _, name := range unusualNames {
+		entries := []TreeEntry{{Mode: "100644", Name: name, Sha: "0123456789abcdef0123456789abcdef01234567"}}
+		content, err := SerializeTree(entries)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", name, err)
+		}
+		parsed, err := ParseTree(content, 20)
+		if err != nil || !reflect.DeepEqual(parsed, entries) {
+			t.Errorf("expected %q to round trip, got %+v, %v", name, parsed, err)
+		}
+	}
+}
+
+func TestProcessTreeUnusualNames(t *testing.T) {
+	dir := useTestRepo(t)
+
+	// mktree -z reads names verbatim, as ls-tree -z writes them. Every file
+	// has its own blob, so that each one is reported under its own path.
+	var listing strings.Builder
+	for i, name := range unusualNames {
+		blob := runGit(t, dir, fmt.Sprintf("key=secret %d\n", i), "hash-object", "-w", "--stdin")
+		listing.WriteString("100644 blob " + blob + "\t" + name + "\x00")
+	}
+	subtree := runGit(t, dir, listing.String(), "mktree", "-z")
+	listing.WriteString("040000 tree " + subtree + "\tsub\tdir\nname\x00")
+	tree := runGit(t, dir, listing.String(), "mktree", "-z")
+
+	newTree, err := ProcessTree(tree, "", NewMatcher([]string{"secret"}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := FlushObjects(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// runGit trims its output, which would eat the leading space of a name.
+	listTree := func(tree string, args ...string) []string {
+		cmd := exec.Command("git", append([]string{"ls-tree", "-r", "-z"}, append(args, tree)...)...)
+		cmd.Dir = dir
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("git ls-tree: %v", err)
+		}
+		return strings.Split(strings.TrimSuffix(string(output), "\x00"), "\x00")
+	}
+	original, rewritten := listTree(tree, "--name-only"), listTree(newTree, "--name-only")
+	if len(original) != 2*len(unusualNames) || !reflect.DeepEqual(rewritten, original) {
+		t.Fatalf("expected the names to be kept in order\nwant %q\ngot  %q", original, rewritten)
+	}
+	for i, entry := range listTree(newTree) {
+		fields := strings.Fields(entry)
+		if content := runGit(t, dir, "", "cat-file", "blob", fields[2]); !strings.HasPrefix(content, "key=**REMOVED** ") {
+			t.Errorf("expected %q to be rewritten, got %q", rewritten[i], content)
+		}
+	}
+
+	paths := make(map[string]bool)
+	for _, path := range original {
+		paths[path] = true
+	}
+	if len(RunReport.Findings) != len(unusualNames) {
+		t.Fatalf("expected a finding for every blob, got %+v", RunReport.Findings)
+	}
+	for _, finding := range RunReport.Findings {
+		if !paths[finding.Path] {
+			t.Errorf("expected findings to be reported under the original path, got %q", finding.Path)
+		}
+	}
+	runGit(t, dir, "", "fsck", "--no-dangling")
+}
@@ -2,34 +2,205 @@ package replacer
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 )
 
-func ReadSecrets(filePath string) ([]string, error) {
+// regexPrefix marks a secrets file line as a regular expression rather than a
+// literal secret.
+const regexPrefix = "regex:"
+
+// commentPrefix starts the comments discover writes into secrets files. Any
+// other line starting with it is an error. A line starting with escapePrefix
+// holds the rest of the line as a literal secret, without any of the prefixes
+// and separators below, as in "\#hunter2" or "\Authorization: Bearer abc".
+const commentPrefix = "#"
+const escapePrefix = `\`
+
+// blockPrefix starts a multi-line secret, as in "<<EOF", that runs until a
+// line holding only the tag. filePrefix reads the secret from a file, relative
+// to the secrets file unless the path is absolute.
+const blockPrefix = "<<"
+const filePrefix = "file:"
+
+// replacementSeparator separates a secret from its replacement, as in
+// "hunter2==>${DB_PASSWORD}".
+const replacementSeparator = "==>"
+
+// DefaultReplacement replaces secrets that have no replacement of their own
+// unless Replacement is changed.
+const DefaultReplacement = "**REMOVED**"
+
+// DefaultNamedReplacement is the template for named secrets that have no
+// replacement of their own unless NamedReplacement is changed. {name} stands
+// for the name of the secret.
+const DefaultNamedReplacement = "**REMOVED:{name}**"
+
+// secretName matches the "name: " prefix of a named entry.
+var secretName = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9_.-]*): `)
+
+// Secret is one entry of the secrets file: a literal value, or a regular
+// expression when Regex is set. HasReplacement tells an empty Replacement,
+// which deletes the secret, from no replacement at all. Named secrets are
+// reported by Name instead of a hash of their value. Encoded variants of an
+// entry, see WithEncodedVariants, hold the encoded value and the Encoding and
+// Original value it came from. Validate, when set, checks matches offline.
+type Secret struct {
+	Name           string
+	Value          string
+	Regex          *regexp.Regexp
+	Replacement    string
+	HasReplacement bool
+	Line           int
+	Encoding       string
+	Original       string
+	Validate       func(value string) Validation
+	variant        int
+}
+
+// String returns the entry as it is written in the secrets file. Multi-line
+// secrets are shortened to their first line and a line count.
+func (s Secret) String() string {
+	entry := s.Value
+	if first, _, multiLine := strings.Cut(entry, "\n"); multiLine {
+		entry = fmt.Sprintf("%s... (%d lines)", first, strings.Count(entry, "\n")+1)
+	}
+	if s.Regex != nil {
+		entry = regexPrefix + entry
+	}
+	if s.HasReplacement {
+		entry += replacementSeparator + s.Replacement
+	}
+	if s.Name != "" {
+		entry = s.Name + ": " + entry
+	}
+	return entry
+}
+
+// Literals turns plain strings into literal secrets.
+func Literals(values []string) []Secret {
+	secrets := make([]Secret, len(values))
+	for i, value := range values {
+		secrets[i] = Secret{Value: value}
+	}
+	return secrets
+}
+
+// ReadSecrets reads one secret per line, skipping blank lines and the comments
+// discover writes. Lines starting with "regex:" hold a regular expression,
+// which is compiled here so that every invalid pattern is reported with its
+// line number before any work is done. A line may start with a name followed
+// by ": ", and end with "==>" and the text to replace the secret with.
+// Multi-line secrets are written as a "<<TAG" block or a "file:path"
+// reference. After a leading "\", the rest of the line is a literal secret.
+func ReadSecrets(filePath string) ([]Secret, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
-	var secrets []string
+	var secrets []Secret
+	var errs []error
 	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		secret := strings.TrimSpace(scanner.Text())
-		if secret != "" {
-			secrets = append(secrets, secret)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		value := strings.TrimSpace(scanner.Text())
+		if value == "" || isDiscoverComment(value) {
+			continue
+		}
+		if strings.HasPrefix(value, commentPrefix) {
+			errs = append(errs, fmt.Errorf("%s:%d: only the comments written by discover are allowed; escape a secret that starts with %s as %s%s", filePath, lineNo, commentPrefix, escapePrefix, commentPrefix))
+			continue
 		}
+
+		secret := Secret{Line: lineNo}
+		if literal, isEscaped := strings.CutPrefix(value, escapePrefix); isEscaped {
+			secret.Value = literal
+		} else {
+			if name := secretName.FindStringSubmatch(value); name != nil {
+				secret.Name = name[1]
+				value = strings.TrimSpace(value[len(name[0]):])
+			}
+			secret.Value, secret.Replacement, secret.HasReplacement = strings.Cut(value, replacementSeparator)
+			if pattern, isRegex := strings.CutPrefix(secret.Value, regexPrefix); isRegex {
+				re, err := compileSecretRegex(pattern)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s:%d: %w", filePath, lineNo, err))
+					continue
+				}
+				secret.Value, secret.Regex = pattern, re
+			} else if tag, isBlock := strings.CutPrefix(secret.Value, blockPrefix); isBlock && tag != "" {
+				block, closed := readBlock(scanner, tag, &lineNo)
+				if !closed {
+					errs = append(errs, fmt.Errorf("%s:%d: block %s%s is never closed by a %s line", filePath, secret.Line, blockPrefix, tag, tag))
+					continue
+				}
+				secret.Value = block
+			} else if ref, isFile := strings.CutPrefix(secret.Value, filePrefix); isFile {
+				if !filepath.IsAbs(ref) {
+					ref = filepath.Join(filepath.Dir(filePath), ref)
+				}
+				content, err := os.ReadFile(ref)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s:%d: %w", filePath, lineNo, err))
+					continue
+				}
+				secret.Value = strings.TrimRight(strings.ReplaceAll(string(content), "\r\n", "\n"), "\n")
+			}
+		}
+
+		if secret.Value == "" && secret.Regex == nil {
+			errs = append(errs, fmt.Errorf("%s:%d: empty secret", filePath, secret.Line))
+			continue
+		}
+		secrets = append(secrets, secret)
 	}
 
 	if err := scanner.Err(); err != nil {
 		return nil, err
 	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
 
-	sort.Slice(secrets, func(i, j int) bool {
-		return len(secrets[i]) > len(secrets[j])
+	sort.SliceStable(secrets, func(i, j int) bool {
+		return len(secrets[i].Value) > len(secrets[j].Value)
 	})
 
 	return secrets, nil
 }
+
+// readBlock reads the lines of a multi-line secret up to a line holding only
+// tag. Line endings are normalized to LF; the matcher finds CRLF copies too.
+func readBlock(scanner *bufio.Scanner, tag string, lineNo *int) (string, bool) {
+	var lines []string
+	for scanner.Scan() {
+		*lineNo++
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == tag {
+			return strings.Join(lines, "\n"), true
+		}
+		lines = append(lines, line)
+	}
+	return "", false
+}
+
+func compileSecretRegex(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, errors.New("empty regex")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex: %w", err)
+	}
+	if re.MatchString("") {
+		return nil, fmt.Errorf("regex %q matches the empty string", pattern)
+	}
+	re.Longest()
+	return re, nil
+}
@@ -2,6 +2,8 @@ package replacer
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -30,8 +32,8 @@ func TestReadSecrets(t *testing.T) {
 		t.Fatalf("expected %d secrets, got %d", len(expected), len(secrets))
 	}
 	for i, secret := range secrets {
-		if secret != expected[i] {
-			t.Errorf("expected secret %q, got %q", expected[i], secret)
+		if secret.Value != expected[i] {
+			t.Errorf("expected secret %q, got %q", expected[i], secret.Value)
 		}
 	}
 }
@@ -61,8 +63,204 @@ func TestReadSecretsSorted(t *testing.T) {
 		t.Fatalf("expected %d secrets, got %d", len(expected), len(secrets))
 	}
 	for i, secret := range secrets {
-		if secret != expected[i] {
-			t.Errorf("expected secret %q, got %q", expected[i], secret)
+		if secret.Value != expected[i] {
+			t.Errorf("expected secret %q, got %q", expected[i], secret.Value)
 		}
 	}
 }
+
+func TestReadSecretsRegex(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "secrets.txt", "literal\nregex:AKIA[0-9A-Z]{16}\n")
+	path := filepath.Join(dir, "secrets.txt")
+
+	secrets, err := ReadSecrets(path)
+	if err != nil {
+		t.Fatalf("ReadSecrets() error = %v", err)
+	}
+	if len(secrets) != 2 {
+		t.Fatalf("expected 2 secrets, got %+v", secrets)
+	}
+	regex := secrets[0]
+	if regex.Regex == nil || regex.Value != "AKIA[0-9A-Z]{16}" || regex.Line != 2 {
+		t.Errorf("unexpected regex secret %+v", regex)
+	}
+	if regex.String() != "regex:AKIA[0-9A-Z]{16}" {
+		t.Errorf("unexpected secret string %q", regex.String())
+	}
+	if secrets[1].Regex != nil || secrets[1].Value != "literal" || secrets[1].Line != 1 {
+		t.Errorf("unexpected literal secret %+v", secrets[1])
+	}
+}
+
+func TestReadSecretsInvalidRegex(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "secrets.txt", "ok\nregex:[a-z\n\nregex:x*\nregex:\n")
+	path := filepath.Join(dir, "secrets.txt")
+
+	_, err := ReadSecrets(path)
+	if err == nil {
+		t.Fatal("expected invalid regexes to be rejected")
+	}
+	for _, expected := range []string{
+		path + ":2: invalid regex",
+		path + ":4: regex \"x*\" matches the empty string",
+		path + ":5: empty regex",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to contain %q, got %v", expected, err)
+		}
+	}
+}
+
+func TestReadSecretsReplacement(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "secrets.txt", "hunter2==>${DB_PASSWORD}\nregex:tok_[a-z]+==>TOKEN\ndeleteme==>\nplain\n")
+
+	secrets, err := ReadSecrets(filepath.Join(dir, "secrets.txt"))
+	if err != nil {
+		t.Fatalf("ReadSecrets() error = %v", err)
+	}
+
+	expected := map[string]struct {
+		replacement    string
+		hasReplacement bool
+	}{
+		"hunter2":    {"${DB_PASSWORD}", true},
+		"tok_[a-z]+": {"TOKEN", true},
+		"deleteme":   {"", true},
+		"plain":      {"", false},
+	}
+	if len(secrets) != len(expected) {
+		t.Fatalf("expected %d secrets, got %+v", len(expected), secrets)
+	}
+	for _, secret := range secrets {
+		want, found := expected[secret.Value]
+		if !found || secret.Replacement != want.replacement || secret.HasReplacement != want.hasReplacement {
+			t.Errorf("unexpected secret %+v", secret)
+		}
+	}
+}
+
+func TestReadSecretsMissingSecret(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "secrets.txt", "ok\n==>replacement\n")
+
+	_, err := ReadSecrets(filepath.Join(dir, "secrets.txt"))
+	if err == nil || !strings.Contains(err.Error(), ":2: empty secret") {
+		t.Errorf("expected an empty secret error on line 2, got %v", err)
+	}
+}
+
+func TestReadSecretsNamed(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "secrets.txt", "prod-stripe-key: sk_live_123\naws: regex:AKIA[0-9A-Z]{16}==>AKIA\nregex:user: admin\n\\Authorization: Bearer abc\n")
+
+	secrets, err := ReadSecrets(filepath.Join(dir, "secrets.txt"))
+	if err != nil {
+		t.Fatalf("ReadSecrets() error = %v", err)
+	}
+
+	byLine := make(map[int]Secret)
+	for _, secret := range secrets {
+		byLine[secret.Line] = secret
+	}
+	if s := byLine[1]; s.Name != "prod-stripe-key" || s.Value != "sk_live_123" || s.Regex != nil {
+		t.Errorf("unexpected named literal %+v", s)
+	}
+	if s := byLine[2]; s.Name != "aws" || s.Value != "AKIA[0-9A-Z]{16}" || s.Regex == nil || s.Replacement != "AKIA" {
+		t.Errorf("unexpected named regex %+v", s)
+	}
+	if s := byLine[3]; s.Name != "" || s.Value != "user: admin" || s.Regex == nil {
+		t.Errorf("unexpected unnamed regex %+v", s)
+	}
+	if s := byLine[4]; s.Name != "" || s.Value != "Authorization: Bearer abc" || s.Regex != nil {
+		t.Errorf("expected the escaped entry to be an unnamed literal, got %+v", s)
+	}
+	if s := byLine[2].String(); s != "aws: regex:AKIA[0-9A-Z]{16}==>AKIA" {
+		t.Errorf("unexpected secret string %q", s)
+	}
+}
+
+func TestReadSecretsMultiLine(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "keys/id.pem", "-----BEGIN KEY-----\r\nabc\r\n-----END KEY-----\r\n")
+	writeFile(t, dir, "secrets.txt", "single\nsa: <<END==>SA\n{\n  \"key\": \"x\"\n}\nEND\npem: file:keys/id.pem\nlast\n")
+
+	secrets, err := ReadSecrets(filepath.Join(dir, "secrets.txt"))
+	if err != nil {
+		t.Fatalf("ReadSecrets() error = %v", err)
+	}
+
+	byLine := make(map[int]Secret)
+	for _, secret := range secrets {
+		byLine[secret.Line] = secret
+	}
+	if s := byLine[2]; s.Name != "sa" || s.Value != "{\n  \"key\": \"x\"\n}" || s.Replacement != "SA" {
+		t.Errorf("unexpected block secret %+v", s)
+	}
+	if s := byLine[7]; s.Name != "pem" || s.Value != "-----BEGIN KEY-----\nabc\n-----END KEY-----" {
+		t.Errorf("unexpected file secret %+v", s)
+	}
+	if s := byLine[8]; s.Value != "last" {
+		t.Errorf("expected line numbers to continue after the block, got %+v", secrets)
+	}
+	if s := byLine[7].String(); s != "pem: -----BEGIN KEY-----... (3 lines)" {
+		t.Errorf("unexpected secret string %q", s)
+	}
+}
+
+func TestReadSecretsAbsoluteFile(t *testing.T) {
+	keys := t.TempDir()
+	writeFile(t, keys, "id_rsa", "-----BEGIN KEY-----\nabc\n-----END KEY-----\n")
+	dir := t.TempDir()
+	writeFile(t, dir, "secrets.txt", "key: file:"+filepath.Join(keys, "id_rsa")+"\n")
+
+	secrets, err := ReadSecrets(filepath.Join(dir, "secrets.txt"))
+	if err != nil {
+		t.Fatalf("ReadSecrets() error = %v", err)
+	}
+	if len(secrets) != 1 || secrets[0].Value != "-----BEGIN KEY-----\nabc\n-----END KEY-----" {
+		t.Errorf("expected the absolute reference to be read as is, got %+v", secrets)
+	}
+}
+
+func TestReadSecretsEscapedComment(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "secrets.txt", "\\#hunter2\n\\\\plain\n\\regex:a+\n\\<<EOF\n\\file:missing.txt\n\\a==>b\n\\name: <<EOF\n")
+
+	secrets, err := ReadSecrets(filepath.Join(dir, "secrets.txt"))
+	if err != nil {
+		t.Fatalf("ReadSecrets() error = %v", err)
+	}
+	byLine := make(map[int]Secret)
+	for _, s := range secrets {
+		byLine[s.Line] = s
+	}
+	want := []string{"#hunter2", `\plain`, "regex:a+", "<<EOF", "file:missing.txt", "a==>b", "name: <<EOF"}
+	for i, value := range want {
+		if s := byLine[i+1]; s.Value != value || s.Name != "" || s.Regex != nil || s.HasReplacement {
+			t.Errorf("expected line %d to be the literal %q, got %+v", i+1, value, s)
+		}
+	}
+}
+
+func TestReadSecretsComment(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "secrets.txt", "hunter2\n#Sup3rS3cret\n")
+
+	_, err := ReadSecrets(filepath.Join(dir, "secrets.txt"))
+	if err == nil || !strings.Contains(err.Error(), ":2: only the comments written by discover are allowed") {
+		t.Errorf("expected the line starting with # to be rejected on line 2, got %v", err)
+	}
+}
+
+func TestReadSecretsUnclosedBlock(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "secrets.txt", "ok\nkey: <<EOF\nline\n")
+
+	_, err := ReadSecrets(filepath.Join(dir, "secrets.txt"))
+	if err == nil || !strings.Contains(err.Error(), ":2: block <<EOF is never closed") {
+		t.Errorf("expected an unclosed block error on line 2, got %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package replacer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"hash/crc32"
+	"strings"
+)
+
+// Validation is the outcome of checking a match offline against the checksum
+// or structure rules of its token format.
+type Validation int
+
+const (
+	// Unchecked means the format has no offline check.
+	Unchecked Validation = iota
+	Valid
+	Invalid
+)
+
+// validators check matches of the detectors with the same ID. Invalid matches
+// are not reported or replaced, and valid ones are marked high-confidence.
+var validators = map[string]func(value string) Validation{
+	"aws-access-key": ValidateAWSAccessKeyID,
+	"github-token":   ValidateGitHubToken,
+	"jwt":            ValidateJWT,
+}
+
+const base62Digits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// ValidateGitHubToken checks the CRC32 checksum that ends classic GitHub
+// tokens such as ghp_: the last 6 characters are the base62 checksum of the 30
+// before them. Fine-grained github_pat_ tokens have no documented checksum.
+func ValidateGitHubToken(token string) Validation {
+	prefix, body, found := strings.Cut(token, "_")
+	if !found || len(prefix) != 3 || !strings.HasPrefix(prefix, "gh") || len(body) != 36 {
+		return Unchecked
+	}
+
+	random, checksum := body[:30], body[30:]
+	crc := crc32.ChecksumIEEE([]byte(random))
+	expected := make([]byte, 6)
+	for i := len(expected) - 1; i >= 0; i-- {
+		expected[i] = base62Digits[crc%62]
+		crc /= 62
+	}
+	if string(expected) != checksum {
+		return Invalid
+	}
+	return Valid
+}
+
+// awsKeyPrefixes are the prefixes of AWS access key IDs and the other unique
+// IDs that share their format.
+var awsKeyPrefixes = []string{"AKIA", "ASIA", "ABIA", "ACCA", "AGPA", "AIDA", "AIPA", "ANPA", "ANVA", "APKA", "AROA"}
+
+// ValidateAWSAccessKeyID checks that id is a known prefix followed by 16
+// characters of the base32 alphabet AWS uses, which has no 0, 1, 8 or 9.
+func ValidateAWSAccessKeyID(id string) Validation {
+	if len(id) != 20 {
+		return Unchecked
+	}
+	known := false
+	for _, prefix := range awsKeyPrefixes {
+		known = known || strings.HasPrefix(id, prefix)
+	}
+	if !known {
+		return Unchecked
+	}
+
+	for i := 4; i < len(id); i++ {
+		if c := id[i]; !('A' <= c && c <= 'Z' || '2' <= c && c <= '7') {
+			return Invalid
+		}
+	}
+	return Valid
+}
+
+// ValidateJWT checks that the header and payload of token decode to JSON
+// objects, the header naming an algorithm, and that the signature decodes.
+func ValidateJWT(token string) Validation {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || !strings.HasPrefix(parts[0], "eyJ") {
+		return Unchecked
+	}
+
+	var header, payload map[string]any
+	if !decodeJWTPart(parts[0], &header) || !decodeJWTPart(parts[1], &payload) {
+		return Invalid
+	}
+	if _, found := header["alg"]; !found {
+		return Invalid
+	}
+	if _, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[2], "=")); err != nil {
+		return Invalid
+	}
+	return Valid
+}
+
+func decodeJWTPart(part string, v any) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(part, "="))
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(decoded, v) == nil
+}
+
+// ValidateToken runs every validator that recognizes the shape of value, for
+// candidates that did not come from a detector.
+func ValidateToken(value string) Validation {
+	for _, validate := range []func(string) Validation{ValidateAWSAccessKeyID, ValidateGitHubToken, ValidateJWT} {
+		if validation := validate(value); validation != Unchecked {
+			return validation
+		}
+	}
+	return Unchecked
+}
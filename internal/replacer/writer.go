@@ -0,0 +1,236 @@
+package replacer
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+var packTypes = map[string]byte{"commit": 1, "tree": 2, "blob": 3, "tag": 4}
+
+// ObjectWriter computes object IDs in-process and appends the objects to a
+// single temporary packfile. Flush hands the pack to git index-pack, so a full
+// rewrite produces one pack instead of a loose object per blob, tree and
+// commit. Objects are not readable from the repository until Flush.
+type ObjectWriter struct {
+	dir     string
+	mu      sync.Mutex
+	newHash func() hash.Hash
+	pack    *os.File
+	written map[string]bool
+	count   uint32
+}
+
+var defaultWriter *ObjectWriter
+var defaultWriterMu sync.Mutex
+
+func NewObjectWriter(dir string) *ObjectWriter {
+	return &ObjectWriter{dir: dir, written: make(map[string]bool)}
+}
+
+// Writer returns the writer shared by the processing functions, which writes
+// to the repository in the current directory.
+func Writer() *ObjectWriter {
+	defaultWriterMu.Lock()
+	defer defaultWriterMu.Unlock()
+	if defaultWriter == nil {
+		defaultWriter = NewObjectWriter("")
+	}
+	return defaultWriter
+}
+
+// FlushObjects stores everything written through the shared writer in the
+// repository.
+func FlushObjects() error {
+	defaultWriterMu.Lock()
+	defer defaultWriterMu.Unlock()
+	if defaultWriter == nil {
+		return nil
+	}
+	return defaultWriter.Flush()
+}
+
+func (w *ObjectWriter) hasher() func() hash.Hash {
+	if w.newHash == nil {
+		cmd := execCommand("git", "rev-parse", "--show-object-format")
+		cmd.Dir = w.dir
+		output, _ := cmd.Output()
+		if strings.TrimSpace(string(output)) == "sha256" {
+			w.newHash = sha256.New
+		} else {
+			w.newHash = sha1.New
+		}
+	}
+	return w.newHash
+}
+
+func (w *ObjectWriter) objectHash(objType string, size int64) hash.Hash {
+	h := w.hasher()()
+	fmt.Fprintf(h, "%s %d\x00", objType, size)
+	return h
+}
+
+// Hash returns the ID content would have as an object of objType, without
+// writing it.
+func (w *ObjectWriter) Hash(objType string, content []byte) string {
+	sha, _ := w.HashFrom(objType, int64(len(content)), bytes.NewReader(content))
+	return sha
+}
+
+// HashFrom is like Hash for size bytes of content read from r.
+func (w *ObjectWriter) HashFrom(objType string, size int64, r io.Reader) (string, error) {
+	w.mu.Lock()
+	h := w.objectHash(objType, size)
+	w.mu.Unlock()
+
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return "", err
+	}
+	if n != size {
+		return "", fmt.Errorf("expected %d bytes of %s content, got %d", size, objType, n)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (w *ObjectWriter) Write(objType string, content []byte) (string, error) {
+	return w.WriteFrom(objType, int64(len(content)), bytes.NewReader(content))
+}
+
+// WriteFrom appends an object of size bytes read from r and returns its ID.
+func (w *ObjectWriter) WriteFrom(objType string, size int64, r io.Reader) (string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	packType, ok := packTypes[objType]
+	if !ok {
+		return "", fmt.Errorf("unsupported object type %q", objType)
+	}
+
+	if w.pack == nil {
+		pack, err := os.CreateTemp("", "git-secrets-replacer-*.pack")
+		if err != nil {
+			return "", err
+		}
+		// The object count is filled in by Flush.
+		if _, err := pack.Write([]byte{'P', 'A', 'C', 'K', 0, 0, 0, 2, 0, 0, 0, 0}); err != nil {
+			pack.Close()
+			os.Remove(pack.Name())
+			return "", err
+		}
+		w.pack = pack
+	}
+
+	offset, err := w.pack.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := w.pack.Write(packEntryHeader(packType, size)); err != nil {
+		return "", err
+	}
+
+	h := w.objectHash(objType, size)
+	zw := zlib.NewWriter(w.pack)
+	n, err := io.Copy(io.MultiWriter(h, zw), r)
+	if err != nil {
+		return "", err
+	}
+	if n != size {
+		return "", fmt.Errorf("expected %d bytes of %s content, got %d", size, objType, n)
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+
+	sha := hex.EncodeToString(h.Sum(nil))
+	if w.written[sha] {
+		return sha, w.pack.Truncate(offset)
+	}
+	w.written[sha] = true
+	w.count++
+	return sha, nil
+}
+
+// packEntryHeader encodes the type and size of a pack entry: three type bits
+// and the low four size bits in the first byte, then seven size bits per byte.
+func packEntryHeader(packType byte, size int64) []byte {
+	header := []byte{packType<<4 | byte(size&0x0f)}
+	size >>= 4
+	for size > 0 {
+		header[len(header)-1] |= 0x80
+		header = append(header, byte(size&0x7f))
+		size >>= 7
+	}
+	return header
+}
+
+// Flush completes the pack and stores it in the repository with git
+// index-pack. The writer can be used again afterwards.
+func (w *ObjectWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.pack == nil {
+		return nil
+	}
+	defer w.discard()
+
+	count := make([]byte, 4)
+	binary.BigEndian.PutUint32(count, w.count)
+	if _, err := w.pack.WriteAt(count, 8); err != nil {
+		return err
+	}
+
+	if _, err := w.pack.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	h := w.hasher()()
+	if _, err := io.Copy(h, w.pack); err != nil {
+		return err
+	}
+	if _, err := w.pack.Write(h.Sum(nil)); err != nil {
+		return err
+	}
+	if _, err := w.pack.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	cmd := execCommand("git", "index-pack", "--stdin")
+	cmd.Dir = w.dir
+	cmd.Stdin = w.pack
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error indexing pack: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	packName := strings.TrimPrefix(strings.TrimSpace(string(output)), "pack\t")
+	fmt.Fprintf(Output, "Wrote %d objects to pack-%s.pack\n", w.count, packName)
+	return nil
+}
+
+func (w *ObjectWriter) discard() {
+	if w.pack != nil {
+		w.pack.Close()
+		os.Remove(w.pack.Name())
+	}
+	w.pack = nil
+	w.count = 0
+	w.written = make(map[string]bool)
+}
+
+// Close drops anything written since the last Flush.
+func (w *ObjectWriter) Close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.discard()
+}
@@ -9,12 +9,40 @@ import (
 	"github.com/TylerStrel/git-secrets-replacer/internal/replacer"
 )
 
-var (
+const (
+	exitOK           = 0
+	exitError        = 1
+	exitUsage        = 2
+	exitSecretsFound = 3
+)
+
+type settings struct {
 	repoPath          string
 	secretsFilePath   string
+	ignoreFilePath    string
+	mailmapPath       string
+	includePaths      []string
+	excludePaths      []string
 	forcePushToOrigin bool
-	secrets           []string
-)
+	assumeYes         bool
+	dryRun            bool
+	memoryBudget      byteSize
+	cacheBudget       byteSize
+	largeBlobSize     byteSize
+	maxBlobSize       byteSize
+	commitMessages    bool
+	tagMessages       bool
+	notes             bool
+	identities        bool
+	replacement       string
+	namedReplacement  string
+	encodings         []string
+	detectors         []replacer.Detector
+	secrets           []replacer.Secret
+	matcher           *replacer.Matcher
+	allowlist         *replacer.Allowlist
+	mailmap           *replacer.Mailmap
+}
 
 func getBanner() string {
 	return `
@@ -33,122 +61,144 @@ func displayUsageInstructions() {
 2. Enter the path to the file containing all the secrets that need to be removed.
 3. Choose whether the changes should be force pushed to the remote/origin (true/false).
 
+To run without prompts, use one of the subcommands (run with --help for details):
+  git-secrets-replacer scan|rewrite|verify|push [flags]
+
 For any issues, feature requests, or more information, visit:
 https://github.com/TylerStrel/git-secrets-replacer`)
 }
-func readSecretsFile(filePath string) ([]string, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return nil, err
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if len(args) == 0 {
+		fmt.Println(getBanner())
+		displayUsageInstructions()
+		return runInteractive(bufio.NewReader(os.Stdin))
 	}
-	defer file.Close()
 
-	var secrets []string
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		secrets = append(secrets, scanner.Text())
+	name := args[0]
+	if strings.HasPrefix(name, "-") && name != "-h" && name != "--help" {
+		// Flags without a subcommand keep the original behaviour of rewriting history.
+		name = "rewrite"
+	} else {
+		args = args[1:]
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, err
+	if name == "help" || name == "-h" || name == "--help" {
+		printCommands()
+		return exitOK
 	}
 
-	return secrets, nil
-}
+	cmd, ok := findCommand(name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		printCommands()
+		return exitUsage
+	}
 
-func main() {
-	fmt.Println(getBanner())
-	displayUsageInstructions()
+	return cmd.run(args)
+}
 
-	reader := bufio.NewReader(os.Stdin)
+func runInteractive(reader *bufio.Reader) int {
+	s := settings{
+		memoryBudget:     replacer.DefaultMemoryBudget,
+		replacement:      replacer.DefaultReplacement,
+		namedReplacement: replacer.DefaultNamedReplacement,
+		commitMessages:   true,
+		tagMessages:      true,
+		notes:            true,
+	}
 
 	fmt.Print("Enter the path to the repo that the code will run on: ")
-	repoPath, _ = reader.ReadString('\n')
-	repoPath = strings.TrimSpace(repoPath)
+	s.repoPath, _ = reader.ReadString('\n')
+	s.repoPath = strings.TrimSpace(s.repoPath)
 
 	fmt.Print("Enter the path to the file containing all the secrets that need to be removed: ")
-	secretsFilePath, _ = reader.ReadString('\n')
-	secretsFilePath = strings.TrimSpace(secretsFilePath)
+	s.secretsFilePath, _ = reader.ReadString('\n')
+	s.secretsFilePath = strings.TrimSpace(s.secretsFilePath)
 
 	fmt.Print("Should the code force push the changes to the remote/origin (true/false)? ")
 	shouldForcePush, _ := reader.ReadString('\n')
 	shouldForcePush = strings.TrimSpace(shouldForcePush)
-	forcePushToOrigin = strings.ToLower(shouldForcePush) == "true"
+	s.forcePushToOrigin = strings.ToLower(shouldForcePush) == "true"
 
-	var err error
-	secrets, err = readSecretsFile(secretsFilePath)
-	if err != nil {
+	if err := loadSecrets(&s); err != nil {
 		fmt.Fprintf(os.Stderr, "Error reading secrets file: %v\n", err)
-		os.Exit(1)
+		return exitError
 	}
-
-	fmt.Println("\nPlease validate the settings:")
-	fmt.Println("Repository Path:", repoPath)
-	fmt.Println("Secrets File Path:", secretsFilePath)
-	fmt.Println("Force Push to Origin:", forcePushToOrigin)
-	fmt.Println("Secrets:")
-	for _, secret := range secrets {
-		fmt.Println("-", secret)
+	if err := loadAllowlist(&s); err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading ignore file: %v\n", err)
+		return exitError
 	}
 
-	fmt.Print("\nAre these settings correct? (yes/no): ")
-	validationResponse, _ := reader.ReadString('\n')
-	validationResponse = strings.TrimSpace(validationResponse)
-
-	if !(strings.ToLower(validationResponse) == "yes" || strings.ToLower(validationResponse) == "y") {
+	if !confirmSettings(reader, s) {
 		fmt.Println("Exiting. Please run the program again with the correct settings.")
-		os.Exit(1)
+		return exitError
 	}
 
-	fmt.Println("Changing directory to:", repoPath)
-	if err := os.Chdir(repoPath); err != nil {
-		fmt.Fprintf(os.Stderr, "Error changing directory: %v\n", err)
-		os.Exit(1)
+	if err := rewriteHistory(s); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
 	}
 
-	refs, err := replacer.GetRefs()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error getting refs: %v\n", err)
-		os.Exit(1)
-	}
+	printFooter()
+	return exitOK
+}
 
-	for _, ref := range refs {
-		fmt.Println("Processing ref:", ref)
-		commits, err := replacer.GetCommits(ref)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error getting commits for ref %s: %v\n", ref, err)
-			os.Exit(1)
+func confirmSettings(reader *bufio.Reader, s settings) bool {
+	fmt.Println("\nPlease validate the settings:")
+	fmt.Println("Repository Path:", s.repoPath)
+	if s.secretsFilePath != "" {
+		fmt.Println("Secrets File Path:", s.secretsFilePath)
+	}
+	if s.ignoreFilePath != "" {
+		fmt.Println("Ignore File Path:", s.ignoreFilePath)
+	}
+	if s.mailmapPath != "" {
+		fmt.Println("Mailmap Path:", s.mailmapPath)
+	}
+	if len(s.includePaths) > 0 {
+		fmt.Println("Include Paths:", strings.Join(s.includePaths, ", "))
+	}
+	if len(s.excludePaths) > 0 {
+		fmt.Println("Exclude Paths:", strings.Join(s.excludePaths, ", "))
+	}
+	fmt.Println("Force Push to Origin:", s.forcePushToOrigin)
+	if s.dryRun {
+		fmt.Println("Dry Run:", s.dryRun)
+	}
+	if len(s.detectors) > 0 {
+		fmt.Println("Detectors:")
+		for _, detector := range s.detectors {
+			fmt.Println("-", detector.ID)
 		}
-
-		var newHead string
-		for i := len(commits) - 1; i >= 0; i-- {
-			commit := commits[i]
-			fmt.Println("Processing commit:", commit)
-			newCommit, err := replacer.ProcessCommit(commit, secrets)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error processing commit %s: %v\n", commit, err)
-				os.Exit(1)
+	}
+	if len(s.secrets) > 0 {
+		fmt.Println("Secrets:")
+		for _, secret := range s.secrets {
+			if secret.Name != "" {
+				fmt.Println("-", secret.Name)
+			} else {
+				fmt.Println("-", secret)
 			}
-			replacer.CommitMap[commit] = newCommit
-			newHead = newCommit
-		}
-
-		fmt.Println("Updating ref:", ref, "to new commit hash:", newHead)
-		if err := replacer.UpdateRef(ref, newHead); err != nil {
-			fmt.Fprintf(os.Stderr, "Error updating ref %s: %v\n", ref, err)
-			os.Exit(1)
 		}
+	}
 
-		if forcePushToOrigin {
-			if err := replacer.ForcePush(ref); err != nil {
-				fmt.Fprintf(os.Stderr, "Error force pushing to origin: %v\n", err)
-				os.Exit(1)
-			}
-		}
+	if s.assumeYes || s.dryRun {
+		return true
 	}
 
-	fmt.Println("Repository has been rewritten successfully.")
+	fmt.Print("\nAre these settings correct? (yes/no): ")
+	validationResponse, _ := reader.ReadString('\n')
+	validationResponse = strings.ToLower(strings.TrimSpace(validationResponse))
+
+	return validationResponse == "yes" || validationResponse == "y"
+}
 
+func printFooter() {
 	fmt.Println("\nFor any issues, feature requests, or more information, visit:")
 	fmt.Println("https://github.com/TylerStrel/git-secrets-replacer")
 }
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git %v: %v", args, err)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// setupRepo creates a repository with one leaked secret and a secrets file
+// listing it. run changes directory, so the working directory is restored
+// afterwards.
+func setupRepo(t *testing.T) (repo, secrets string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	repo = t.TempDir()
+	runGit(t, repo, "init", "-q")
+	runGit(t, repo, "config", "user.name", "Test")
+	runGit(t, repo, "config", "user.email", "test@example.com")
+	if err := os.WriteFile(filepath.Join(repo, "config.txt"), []byte("password=hunter2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, repo, "add", "-A")
+	runGit(t, repo, "commit", "-q", "-m", "first")
+
+	secrets = filepath.Join(t.TempDir(), "secrets.txt")
+	if err := os.WriteFile(secrets, []byte("hunter2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return repo, secrets
+}
+
+func TestRun(t *testing.T) {
+	repo, secrets := setupRepo(t)
+	missing := filepath.Join(t.TempDir(), "missing.txt")
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"help", []string{"help"}, exitOK},
+		{"help flag", []string{"--help"}, exitOK},
+		{"command help", []string{"scan", "--help"}, exitOK},
+		{"unknown command", []string{"bogus"}, exitUsage},
+		{"unknown flag", []string{"scan", "--bogus"}, exitUsage},
+		{"missing secrets flag", []string{"scan", "--repoPath", repo}, exitUsage},
+		{"unexpected argument", []string{"scan", "--repoPath", repo, "--secretsFilePath", secrets, "extra"}, exitUsage},
+		{"unreadable secrets file", []string{"scan", "--repoPath", repo, "--secretsFilePath", missing}, exitError},
+		{"missing repository", []string{"scan", "--repoPath", filepath.Join(repo, "missing"), "--secretsFilePath", secrets}, exitError},
+		{"scan finds secrets", []string{"scan", "--repoPath", repo, "--secretsFilePath", secrets}, exitSecretsFound},
+		{"verify finds secrets", []string{"verify", "--repoPath", repo, "--secretsFilePath", secrets}, exitSecretsFound},
+		{"dry run", []string{"rewrite", "--repoPath", repo, "--secretsFilePath", secrets, "--dry-run"}, exitOK},
+		{"scan after dry run", []string{"scan", "--repoPath", repo, "--secretsFilePath", secrets}, exitSecretsFound},
+		{"discover", []string{"discover", "--repoPath", repo}, exitOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := run(tt.args); got != tt.want {
+				t.Errorf("run(%q) = %d, want %d", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRun_BareFlagsRewrite(t *testing.T) {
+	repo, secrets := setupRepo(t)
+	head := runGit(t, repo, "rev-parse", "HEAD")
+
+	if got := run([]string{"--repoPath", repo, "--secretsFilePath", secrets, "--yes"}); got != exitOK {
+		t.Fatalf("expected flags without a subcommand to rewrite, got exit code %d", got)
+	}
+	if newHead := runGit(t, repo, "rev-parse", "HEAD"); newHead == head {
+		t.Error("expected the branch to be rewritten")
+	}
+	if got := runGit(t, repo, "show", "HEAD:config.txt"); got != "password=**REMOVED**" {
+		t.Errorf("expected the secret to be replaced, got %q", got)
+	}
+
+	for _, name := range []string{"scan", "verify"} {
+		if got := run([]string{name, "--repoPath", repo, "--secretsFilePath", secrets}); got != exitOK {
+			t.Errorf("expected %s to pass after the rewrite, got exit code %d", name, got)
+		}
+	}
+}